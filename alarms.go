@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alarm is a wake-up alarm that fires at a time of day, optionally repeating on weekdays
+type Alarm struct {
+	ID       int    `json:"id"`
+	Time     string `json:"time"`           // Time of day, "15:04"
+	Days     []int  `json:"days,omitempty"` // Repeat days, 0 = Sunday ... 6 = Saturday. Empty = fire once
+	Enabled  bool   `json:"enabled"`
+	Label    string `json:"label,omitempty"`
+	Override bool   `json:"override,omitempty"` // Rings during quiet hours
+}
+
+type AlarmMessage struct {
+	Type  string `json:"type"`
+	ID    int    `json:"id"`
+	Label string `json:"label,omitempty"`
+}
+
+// AlarmStore holds the alarms and persists them to a JSON file
+type AlarmStore struct {
+	alarms map[int]*Alarm
+	nextID int
+	path   string
+	mutex  sync.RWMutex
+}
+
+var alarmStore *AlarmStore
+
+func newAlarmStore(path string) *AlarmStore {
+	return &AlarmStore{
+		alarms: make(map[int]*Alarm),
+		nextID: 1,
+		path:   path,
+	}
+}
+
+func (s *AlarmStore) validate(alarm *Alarm) error {
+	t, err := time.Parse("15:04", alarm.Time)
+	if err != nil {
+		return fmt.Errorf("time must be in HH:MM format")
+	}
+	// Stored zero-padded so 7:30 matches the scheduler's 07:30
+	alarm.Time = t.Format("15:04")
+	for _, day := range alarm.Days {
+		if day < 0 || day > 6 {
+			return fmt.Errorf("days must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	}
+	return nil
+}
+
+func (s *AlarmStore) add(alarm Alarm) (Alarm, error) {
+	if err := s.validate(&alarm); err != nil {
+		return Alarm{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	alarm.ID = s.nextID
+	s.nextID++
+	s.alarms[alarm.ID] = &alarm
+
+	if err := s.saveLocked(); err != nil {
+		log.Printf("Failed to persist alarms: %v", err)
+	}
+	return alarm, nil
+}
+
+func (s *AlarmStore) remove(id int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.alarms[id]; !ok {
+		return false
+	}
+	delete(s.alarms, id)
+
+	if err := s.saveLocked(); err != nil {
+		log.Printf("Failed to persist alarms: %v", err)
+	}
+	return true
+}
+
+func (s *AlarmStore) list() []Alarm {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	alarms := make([]Alarm, 0, len(s.alarms))
+	for id := 1; id < s.nextID; id++ {
+		if alarm, ok := s.alarms[id]; ok {
+			alarms = append(alarms, *alarm)
+		}
+	}
+	return alarms
+}
+
+// due returns the alarms that should fire at the given minute. One-shot alarms are disabled once due.
+func (s *AlarmStore) due(now time.Time) []Alarm {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var fired []Alarm
+	changed := false
+	minute := now.Format("15:04")
+	weekday := int(now.Weekday())
+
+	for _, alarm := range s.alarms {
+		if !alarm.Enabled || alarm.Time != minute {
+			continue
+		}
+
+		if len(alarm.Days) == 0 {
+			alarm.Enabled = false
+			changed = true
+		} else if !containsDay(alarm.Days, weekday) {
+			continue
+		}
+
+		fired = append(fired, *alarm)
+	}
+
+	if changed {
+		if err := s.saveLocked(); err != nil {
+			log.Printf("Failed to persist alarms: %v", err)
+		}
+	}
+	return fired
+}
+
+func containsDay(days []int, day int) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *AlarmStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var alarms []Alarm
+	if err := json.Unmarshal(data, &alarms); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := range alarms {
+		alarm := alarms[i]
+		// Also pads times saved before validate normalized them
+		if err := s.validate(&alarm); err != nil {
+			log.Printf("Skipping alarm %d in %s: %v", alarm.ID, s.path, err)
+			continue
+		}
+		s.alarms[alarm.ID] = &alarm
+		if alarm.ID >= s.nextID {
+			s.nextID = alarm.ID + 1
+		}
+	}
+	log.Printf("Loaded %d alarms from %s", len(alarms), s.path)
+	return nil
+}
+
+// saveLocked writes the alarms to disk, the caller must hold the mutex
+func (s *AlarmStore) saveLocked() error {
+	alarms := make([]Alarm, 0, len(s.alarms))
+	for _, alarm := range s.alarms {
+		alarms = append(alarms, *alarm)
+	}
+
+	data, err := json.MarshalIndent(alarms, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// runAlarms checks the alarms at the start of every minute and notifies clients when one fires
+func runAlarms(hub *Hub) {
+	now := time.Now()
+	time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now().In(defaultLocation())
+		for _, alarm := range alarmStore.due(now) {
+			if !alarm.Override && quietHours.active(now) {
+				log.Printf("Alarm %d suppressed by quiet hours", alarm.ID)
+				continue
+			}
+			log.Printf("Alarm %d fired", alarm.ID)
+			broadcastAlarm(hub, alarm)
+			webhookNotifier.notify(webhookAlarmFired, map[string]interface{}{"id": alarm.ID, "label": alarm.Label})
+		}
+		<-ticker.C
+	}
+}
+
+func broadcastAlarm(hub *Hub, alarm Alarm) {
+	msg := AlarmMessage{
+		Type:  "alarm",
+		ID:    alarm.ID,
+		Label: alarm.Label,
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling alarm message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "alarm")
+}
+
+func handleAlarms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alarmStore.list())
+	case http.MethodPost:
+		req := Alarm{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		alarm, err := alarmStore.add(req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Alarm %d created for %s", alarm.ID, alarm.Time)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(alarm)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func handleAlarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/alarms/"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid alarm ID")
+		return
+	}
+
+	if !alarmStore.remove(id) {
+		writeJSONError(w, http.StatusNotFound, "Alarm not found")
+		return
+	}
+
+	log.Printf("Alarm %d deleted", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// APIError is the body of every failed API request: {"error": {"code": ..., "message": ...}}
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+type APIErrorDetail struct {
+	Code    string `json:"code"`    // Derived from the status, e.g. "bad_request" or "service_unavailable"
+	Message string `json:"message"` // Human readable, safe to show in a UI
+}
+
+func errorCode(status int) string {
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// writeJSONError replaces http.Error so API clients always get JSON back
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	header := w.Header()
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Error: APIErrorDetail{Code: errorCode(status), Message: message}})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DropPolicy decides what happens to a frame when a multiplexer channel is full
+type DropPolicy string
+
+const (
+	dropNewest DropPolicy = "drop-newest" // Discard the incoming frame, keeps latency bounded
+	dropOldest DropPolicy = "drop-oldest" // Discard the oldest queued frame, keeps the freshest audio
+	blockSend  DropPolicy = "block"       // Wait up to AUDIO_BLOCK_TIMEOUT_MS, favors continuity
+)
+
+// Multiplexer buffering, in frames (AUDIO_SOURCE_BUFFER, AUDIO_LISTENER_BUFFER)
+var (
+	audioSourceBuffer   = envInt("AUDIO_SOURCE_BUFFER", 100)
+	audioListenerBuffer = envInt("AUDIO_LISTENER_BUFFER", 50)
+	audioBlockTimeout   = time.Duration(envInt("AUDIO_BLOCK_TIMEOUT_MS", 20)) * time.Millisecond
+	audioDropPolicy     = parseDropPolicy(os.Getenv("AUDIO_DROP_POLICY"))
+)
+
+func parseDropPolicy(value string) DropPolicy {
+	switch policy := DropPolicy(value); policy {
+	case dropOldest, blockSend:
+		return policy
+	case "", dropNewest:
+		return dropNewest
+	default:
+		log.Printf("Unknown AUDIO_DROP_POLICY %q, using %s", value, dropNewest)
+		return dropNewest
+	}
+}
+
+// offerFrame queues a frame on ch according to the drop policy and reports whether a frame
+// was lost, either this one or an older one it displaced
+func offerFrame(ch chan []byte, frame []byte) (dropped bool) {
+	select {
+	case ch <- frame:
+		return false
+	default:
+	}
+
+	switch audioDropPolicy {
+	case dropOldest:
+		// The consumer may have emptied the channel in between, so both steps are non-blocking
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- frame:
+		default:
+		}
+		return true
+	case blockSend:
+		timer := time.NewTimer(audioBlockTimeout)
+		defer timer.Stop()
+		select {
+		case ch <- frame:
+			return false
+		case <-timer.C:
+			return true
+		}
+	default:
+		return true
+	}
+}
+
+// audioListener is one multiplexer subscriber and its drop counter
+type audioListener struct {
+	id           uint64
+	name         string // Consumer kind: webrtc, http or mp3
+	subscribedAt time.Time
+	dropped      atomic.Uint64
+}
+
+var lastListenerID atomic.Uint64
+
+// ListenerStats is one listener as reported by /api/audio/buffers
+type ListenerStats struct {
+	ID           uint64    `json:"id"`
+	Name         string    `json:"name"`
+	SubscribedAt time.Time `json:"subscribedAt"`
+	Buffered     int       `json:"buffered"`
+	Capacity     int       `json:"capacity"`
+	Dropped      uint64    `json:"dropped"`
+}
+
+func (am *AudioMultiplexer) listenerStats() []ListenerStats {
+	am.listenersMutex.RLock()
+	defer am.listenersMutex.RUnlock()
+
+	stats := []ListenerStats{}
+	for ch, listener := range am.listeners {
+		stats = append(stats, ListenerStats{
+			ID:           listener.id,
+			Name:         listener.name,
+			SubscribedAt: listener.subscribedAt,
+			Buffered:     len(ch),
+			Capacity:     cap(ch),
+			Dropped:      listener.dropped.Load(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+func handleAudioBuffers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string]interface{}{
+		"policy":         audioDropPolicy,
+		"sourceBuffer":   audioSourceBuffer,
+		"sourceBuffered": len(audioMultiplexer.sourceChannel),
+		"sourceDropped":  sourceFramesDropped.Load(),
+		"listenerBuffer": audioListenerBuffer,
+		"listeners":      audioMultiplexer.listenerStats(),
+	}
+	if audioDropPolicy == blockSend {
+		response["blockTimeoutMs"] = audioBlockTimeout.Milliseconds()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// eofSource is an AudioSource whose streams end straight away, like a parec that keeps dying
+type eofSource struct {
+	opened chan struct{}
+}
+
+func (s *eofSource) String() string {
+	return "EOF test source"
+}
+
+func (s *eofSource) Open() (io.ReadCloser, error) {
+	select {
+	case s.opened <- struct{}{}:
+	default:
+	}
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+// useAudioSource swaps the capture source for the duration of a test
+func useAudioSource(t *testing.T, source AudioSource) {
+	previous := audioSource
+	audioSource = source
+	t.Cleanup(func() {
+		stopAudioCapture()
+		audioSource = previous
+	})
+}
+
+func TestCaptureRestartsAfterEOF(t *testing.T) {
+	source := &eofSource{opened: make(chan struct{}, 10)}
+	useAudioSource(t, source)
+
+	// The capture is only restarted while someone listens
+	listener := audioMultiplexer.subscribe("test")
+	t.Cleanup(func() { audioMultiplexer.unsubscribe(listener) })
+
+	if err := ensureAudioCapture(); err != nil {
+		t.Fatalf("ensureAudioCapture: %v", err)
+	}
+
+	for _, want := range []string{"opened", "reopened after EOF"} {
+		select {
+		case <-source.opened:
+		case <-time.After(minCaptureRestartDelay + 2*time.Second):
+			t.Fatalf("audio source was not %s", want)
+		}
+	}
+}
+
+func TestDrainAudioPipe(t *testing.T) {
+	const frameSize = 4
+	// Non-zero samples so nothing reads as silence
+	data := []byte("abcdefghijklmnopqrstuvwxyz")
+
+	tests := []struct {
+		name   string
+		reader io.Reader
+		frames []string
+	}{
+		{"whole frames", bytes.NewReader(data[:24]), []string{"abcd", "efgh", "ijkl", "mnop", "qrst", "uvwx"}},
+		{"partial trailing frame", bytes.NewReader(data), []string{"abcd", "efgh", "ijkl", "mnop", "qrst", "uvwx"}},
+		{"less than a frame", bytes.NewReader(data[:3]), nil},
+		{"empty", bytes.NewReader(nil), nil},
+		{"one byte reads", iotest.OneByteReader(bytes.NewReader(data[:10])), []string{"abcd", "efgh"}},
+		{"half reads", iotest.HalfReader(bytes.NewReader(data[:12])), []string{"abcd", "efgh", "ijkl"}},
+		{"data with EOF", iotest.DataErrReader(bytes.NewReader(data[:8])), []string{"abcd", "efgh"}},
+		{"read error", io.MultiReader(bytes.NewReader(data[:6]), iotest.ErrReader(errors.New("broken pipe"))), []string{"abcd"}},
+	}
+
+	// A restart left over from another test then reads nothing instead of a real source
+	useAudioSource(t, &eofSource{opened: make(chan struct{}, 1)})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener := audioMultiplexer.subscribe("test")
+			defer audioMultiplexer.unsubscribe(listener)
+
+			drainAudioPipe(tt.reader, frameSize)
+
+			for i, want := range tt.frames {
+				select {
+				case frame := <-listener:
+					if len(frame) != frameSize {
+						t.Fatalf("frame %d is %d bytes, want %d", i, len(frame), frameSize)
+					}
+					if string(frame) != want {
+						t.Fatalf("frame %d is %q, want %q", i, frame, want)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("got %d frames, want %d", i, len(tt.frames))
+				}
+			}
+			select {
+			case frame := <-listener:
+				t.Fatalf("unexpected frame %q after %d frames", frame, len(tt.frames))
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// g711Decimation is the ratio between the 48kHz capture and G.711's 8kHz clock
+const g711Decimation = captureSampleRate / 8000
+
+// AudioEncoder turns one frame of 48kHz PCM, interleaved with the configured channel count, into
+// a packet for its codec. *opus.Encoder satisfies it directly.
+type AudioEncoder interface {
+	Encode(pcm []int16, data []byte) (int, error)
+}
+
+// audioCodec is a codec the server can stream WebRTC audio with
+type audioCodec struct {
+	name     string // As it appears in SDP rtpmap lines and WEBRTC_CODEC
+	mimeType string
+}
+
+var (
+	codecOpus = audioCodec{name: "opus", mimeType: webrtc.MimeTypeOpus}
+	codecPCMU = audioCodec{name: "PCMU", mimeType: webrtc.MimeTypePCMU}
+	codecPCMA = audioCodec{name: "PCMA", mimeType: webrtc.MimeTypePCMA}
+)
+
+// audioCodecs are the supported codecs in order of preference
+var audioCodecs = []audioCodec{codecOpus, codecPCMU, codecPCMA}
+
+// forcedCodec is WEBRTC_CODEC, tried before the usual order when a browser offers it
+var forcedCodec = parseForcedCodec(os.Getenv("WEBRTC_CODEC"))
+
+func parseForcedCodec(value string) *audioCodec {
+	if value == "" {
+		return nil
+	}
+	for _, codec := range audioCodecs {
+		if strings.EqualFold(value, codec.name) {
+			return &codec
+		}
+	}
+	log.Printf("Unknown WEBRTC_CODEC %q, negotiating from the offer", value)
+	return nil
+}
+
+// offeredAudioCodecs lists the lowercased codec names in the offer's audio sections
+func offeredAudioCodecs(offer *webrtc.SessionDescription) (map[string]bool, error) {
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return nil, err
+	}
+
+	offered := make(map[string]bool)
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "audio" {
+			continue
+		}
+		for _, attribute := range media.Attributes {
+			if attribute.Key != "rtpmap" {
+				continue
+			}
+			// "111 opus/48000/2"
+			_, encoding, ok := strings.Cut(attribute.Value, " ")
+			if !ok {
+				continue
+			}
+			name, _, _ := strings.Cut(encoding, "/")
+			offered[strings.ToLower(name)] = true
+		}
+	}
+	return offered, nil
+}
+
+// negotiateCodec picks the codec for an offer: WEBRTC_CODEC if offered, otherwise Opus, falling
+// back to G.711 for clients without it
+func negotiateCodec(offer *webrtc.SessionDescription) audioCodec {
+	offered, err := offeredAudioCodecs(offer)
+	if err != nil {
+		log.Printf("Failed to parse offer codecs, using Opus: %v", err)
+		return codecOpus
+	}
+
+	candidates := audioCodecs
+	if forcedCodec != nil {
+		candidates = append([]audioCodec{*forcedCodec}, audioCodecs...)
+	}
+	for _, codec := range candidates {
+		if offered[strings.ToLower(codec.name)] {
+			if forcedCodec != nil && codec != *forcedCodec {
+				log.Printf("Offer doesn't support WEBRTC_CODEC %s, falling back to %s", forcedCodec.name, codec.name)
+			}
+			return codec
+		}
+	}
+
+	log.Println("Offer lists no supported audio codec, answering with Opus")
+	return codecOpus
+}
+
+func newAudioEncoder(mimeType string, settings AudioSettings, channels int) (AudioEncoder, error) {
+	switch mimeType {
+	case webrtc.MimeTypeOpus:
+		return newOpusEncoder(settings, channels)
+	case webrtc.MimeTypePCMU:
+		return &g711Encoder{channels: channels, compress: linearToMulaw}, nil
+	case webrtc.MimeTypePCMA:
+		return &g711Encoder{channels: channels, compress: linearToAlaw}, nil
+	}
+	return nil, fmt.Errorf("unsupported codec %s", mimeType)
+}
+
+// g711Encoder mixes down to mono, decimates to 8kHz and compands each sample to a byte. It is
+// far cheaper than Opus, at telephone quality.
+type g711Encoder struct {
+	channels int
+	compress func(int16) byte
+}
+
+func (ge *g711Encoder) Encode(pcm []int16, data []byte) (int, error) {
+	// Averaging each group of input samples is a crude low-pass, enough against aliasing for speech
+	group := g711Decimation * ge.channels
+	samples := len(pcm) / group
+	if len(data) < samples {
+		return 0, fmt.Errorf("buffer too small for %d G.711 samples", samples)
+	}
+
+	for i := 0; i < samples; i++ {
+		sum := 0
+		for _, sample := range pcm[i*group : (i+1)*group] {
+			sum += int(sample)
+		}
+		data[i] = ge.compress(int16(sum / group))
+	}
+	return samples, nil
+}
+
+// linearToMulaw is the G.711 µ-law compander
+func linearToMulaw(sample int16) byte {
+	const bias = 0x84
+	const clip = 32635
+
+	value := int(sample)
+	sign := 0
+	if value < 0 {
+		value = -value
+		sign = 0x80
+	}
+	if value > clip {
+		value = clip
+	}
+	value += bias
+
+	exponent := 7
+	for mask := 0x4000; value&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (value >> (exponent + 3)) & 0x0F
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+// linearToAlaw is the G.711 A-law compander
+func linearToAlaw(sample int16) byte {
+	value := int(sample) >> 3 // A-law works on 13 bits
+	mask := 0xD5
+	if value < 0 {
+		mask = 0x55
+		value = -value - 1
+	}
+
+	segment := 0
+	for end := 0x1F; value > end; end = end<<1 | 1 {
+		segment++
+		if segment == 8 {
+			return byte(0x7F ^ mask)
+		}
+	}
+
+	encoded := segment << 4
+	if segment < 2 {
+		encoded |= (value >> 1) & 0x0F
+	} else {
+		encoded |= (value >> segment) & 0x0F
+	}
+	return byte(encoded ^ mask)
+}
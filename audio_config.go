@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture format shared by parec, the multiplexer and every consumer: 48kHz stereo s16le
+const (
+	captureSampleRate = 48000
+	captureChannels   = 2
+	captureFrameBytes = captureChannels * 2 // Bytes per sample across both channels
+)
+
+// opusFrameDurations are the frame lengths in milliseconds Opus can encode
+var opusFrameDurations = []float64{2.5, 5, 10, 20, 40, 60}
+
+// AudioSettings are the tunable parameters of the audio pipeline
+type AudioSettings struct {
+	Bitrate          int     `json:"bitrate"`          // Opus bitrate in bits per second
+	Complexity       int     `json:"complexity"`       // Opus encoder complexity, 0-10
+	SilenceThreshold int     `json:"silenceThreshold"` // Peak amplitude below which a frame is silent, 0 disables pausing
+	SilenceFrames    int     `json:"silenceFrames"`    // Consecutive silent frames before the stream pauses
+	Mono             bool    `json:"mono"`             // Downmix to one channel, applies to streams started afterwards
+	FrameDuration    float64 `json:"frameDuration"`    // PCM/Opus frame length in milliseconds, changing it restarts capture
+	Application      string  `json:"application"`      // Opus application mode: voip, audio or lowdelay
+}
+
+// AudioConfig holds the active audio settings, read when encoders are created and while streaming
+type AudioConfig struct {
+	settings AudioSettings
+	device   string // PulseAudio source captured by parec
+	mutex    sync.RWMutex
+}
+
+var audioConfig = &AudioConfig{
+	settings: AudioSettings{
+		Bitrate:          128000,
+		Complexity:       5,   // Balance between quality and speed
+		SilenceThreshold: 100, // Amplitude threshold for silence detection
+		SilenceFrames:    25,  // 25 frames = 500ms of silence before pausing
+		FrameDuration:    20,
+		Application:      "audio", // Tuned for music, voip and lowdelay suit an intercom
+	},
+	device: defaultAudioDevice(),
+}
+
+// AudioDevice is a PulseAudio source as reported by pactl
+type AudioDevice struct {
+	Index      string `json:"index"`
+	Name       string `json:"name"`
+	Driver     string `json:"driver"`
+	SampleSpec string `json:"sampleSpec"`
+	State      string `json:"state"`
+}
+
+func defaultAudioDevice() string {
+	if device := os.Getenv("AUDIO_DEVICE"); device != "" {
+		return device
+	}
+	return "snapcast_sink.monitor"
+}
+
+func (ac *AudioConfig) get() AudioSettings {
+	ac.mutex.RLock()
+	defer ac.mutex.RUnlock()
+	return ac.settings
+}
+
+func (ac *AudioConfig) getDevice() string {
+	ac.mutex.RLock()
+	defer ac.mutex.RUnlock()
+	return ac.device
+}
+
+func (ac *AudioConfig) setDevice(device string) {
+	ac.mutex.Lock()
+	ac.device = device
+	ac.mutex.Unlock()
+}
+
+// frameDuration is the length of one PCM frame
+func (s AudioSettings) frameDuration() time.Duration {
+	return time.Duration(s.FrameDuration * float64(time.Millisecond))
+}
+
+// pcmFrameSize is the size in bytes of one captured frame, e.g. 3840 for 20ms
+func (s AudioSettings) pcmFrameSize() int {
+	return int(captureSampleRate*s.frameDuration()/time.Second) * captureFrameBytes
+}
+
+func (s AudioSettings) validate() error {
+	if s.Bitrate < 8000 || s.Bitrate > 510000 {
+		return fmt.Errorf("bitrate must be between 8000 and 510000")
+	}
+	if s.Complexity < 0 || s.Complexity > 10 {
+		return fmt.Errorf("complexity must be between 0 and 10")
+	}
+	if s.SilenceThreshold < 0 || s.SilenceThreshold > 32767 {
+		return fmt.Errorf("silenceThreshold must be between 0 and 32767")
+	}
+	if s.SilenceFrames < 1 {
+		return fmt.Errorf("silenceFrames must be at least 1")
+	}
+	if _, ok := opusApplications[s.Application]; !ok {
+		return fmt.Errorf("application must be one of voip, audio or lowdelay")
+	}
+	for _, duration := range opusFrameDurations {
+		if s.FrameDuration == duration {
+			return nil
+		}
+	}
+	return fmt.Errorf("frameDuration must be one of 2.5, 5, 10, 20, 40 or 60")
+}
+
+func (ac *AudioConfig) set(settings AudioSettings) error {
+	if err := settings.validate(); err != nil {
+		return err
+	}
+
+	ac.mutex.Lock()
+	ac.settings = settings
+	ac.mutex.Unlock()
+	return nil
+}
+
+func handleAudioConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// Start from the active settings so omitted fields are left unchanged
+		settings := audioConfig.get()
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		previous := audioConfig.get()
+		if err := audioConfig.set(settings); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Audio config updated via HTTP: %+v", settings)
+
+		// The frame size is fixed when parec starts, so a new one needs a fresh capture process
+		if settings.FrameDuration != previous.FrameDuration {
+			if err := restartAudioCapture(); err != nil {
+				writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to restart audio capture: %v", err))
+				return
+			}
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(audioConfig.get())
+}
+
+// listAudioDevices asks PulseAudio for the available capture sources
+func listAudioDevices() ([]AudioDevice, error) {
+	if _, err := exec.LookPath("pactl"); err != nil {
+		return nil, fmt.Errorf("pactl is not available on this system")
+	}
+
+	output, err := exec.Command("pactl", "list", "sources", "short").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audio sources: %v", err)
+	}
+
+	devices := []AudioDevice{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+
+		device := AudioDevice{Index: fields[0], Name: fields[1]}
+		if len(fields) > 2 {
+			device.Driver = fields[2]
+		}
+		if len(fields) > 3 {
+			device.SampleSpec = fields[3]
+		}
+		if len(fields) > 4 {
+			device.State = fields[4]
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// restartAudioCapture stops the capture process and starts it again if anyone is listening
+func restartAudioCapture() error {
+	stopAudioCapture()
+	if audioMultiplexer.listenerCount() == 0 {
+		return nil
+	}
+	return ensureAudioCapture()
+}
+
+func handleAudioDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	devices, err := listAudioDevices()
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"active":  audioConfig.getDevice(),
+		"devices": devices,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func handleSetAudioDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Device string `json:"device"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	devices, err := listAudioDevices()
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	found := false
+	for _, device := range devices {
+		if device.Name == req.Device {
+			found = true
+			break
+		}
+	}
+	if !found {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Unknown audio device %q", req.Device))
+		return
+	}
+
+	audioConfig.setDevice(req.Device)
+	log.Printf("Audio device set to %s via HTTP", req.Device)
+
+	if err := restartAudioCapture(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to restart audio capture: %v", err))
+		return
+	}
+
+	response := map[string]string{"device": req.Device}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// audioFadeEnabled ramps a WebRTC stream over one frame where it would otherwise start or stop
+// abruptly and click on the receiver: when a track leaves or pauses for another tab, when it comes
+// back, and when the stream resumes after silence (AUDIO_FADE=true)
+var audioFadeEnabled = os.Getenv("AUDIO_FADE") == "true"
+
+// fadeOutTimeout bounds how long a leaving track waits for its fade-out frame, which never comes
+// while the stream is paused for silence
+const fadeOutTimeout = 200 * time.Millisecond
+
+// applyRamp writes pcm into dst with a gain moving linearly from one value to the other across
+// the frame, both channels of a sample getting the same gain. dst may be pcm.
+func applyRamp(dst, pcm []int16, channels int, from, to float64) {
+	samples := len(pcm) / channels
+	for i := 0; i < samples; i++ {
+		gain := from + (to-from)*float64(i)/float64(samples)
+		for c := 0; c < channels; c++ {
+			dst[i*channels+c] = int16(float64(pcm[i*channels+c]) * gain)
+		}
+	}
+}
+
+// leave removes a subscriber whose stream stopped. With AUDIO_FADE the encoder sends it one frame
+// fading to silence first, falling back to a plain removal after fadeOutTimeout.
+func (se *SharedEncoder) leave(subscriber *trackSubscriber) {
+	if !audioFadeEnabled {
+		se.remove(subscriber)
+		return
+	}
+
+	subscriber.leaving.Store(true)
+	select {
+	case <-subscriber.removed:
+	case <-time.After(fadeOutTimeout):
+		se.remove(subscriber)
+	}
+}
+
+// fade sends every group one frame of pcm ramped between the gains. The frame comes from a fresh
+// encoder so the ramp doesn't disturb the state of the shared ones, like the silence encoders.
+func (se *SharedEncoder) fade(groups map[encoderKey][]*trackSubscriber, failures map[encoderKey]int, settings AudioSettings, channels int,
+	buffer, pcm []int16, from, to float64, packet []byte, duration time.Duration) {
+	if len(groups) == 0 {
+		return
+	}
+
+	applyRamp(buffer, pcm, channels, from, to)
+	for key, subscribers := range groups {
+		se.encodeAndWrite(map[encoderKey]AudioEncoder{}, failures, key, settings, channels, buffer, packet, subscribers, duration)
+	}
+}
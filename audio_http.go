@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net/http"
+)
+
+const pcmBitsPerSample = 16
+
+// wavUnknownSize stands in for the sizes of a WAV stream that has no end
+const wavUnknownSize = 0xFFFFFFFF
+
+// wavStreamHeader builds a WAV header with maximal sizes, as the stream has no end
+func wavStreamHeader() []byte {
+	return wavHeader(wavUnknownSize)
+}
+
+// wavHeader builds the 44 byte header of a WAV file holding dataSize bytes of PCM
+func wavHeader(dataSize uint32) []byte {
+	byteRate := captureSampleRate * captureChannels * pcmBitsPerSample / 8
+	blockAlign := captureChannels * pcmBitsPerSample / 8
+
+	riffSize := uint32(wavUnknownSize)
+	if dataSize != wavUnknownSize {
+		riffSize = 36 + dataSize
+	}
+
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], riffSize)
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(header[20:], 1)  // PCM
+	binary.LittleEndian.PutUint16(header[22:], captureChannels)
+	binary.LittleEndian.PutUint32(header[24:], captureSampleRate)
+	binary.LittleEndian.PutUint32(header[28:], uint32(byteRate))
+	binary.LittleEndian.PutUint16(header[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:], pcmBitsPerSample)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], dataSize)
+	return header
+}
+
+// handleAudioStream serves the multiplexer audio over plain HTTP for clients without WebRTC.
+// It streams WAV by default, or raw s16le PCM with ?format=raw.
+func handleAudioStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	raw := r.URL.Query().Get("format") == "raw"
+
+	// Subscribe first so an idle shutdown can't race the capture start
+	audioChannel := audioMultiplexer.subscribe("http")
+	defer audioMultiplexer.unsubscribe(audioChannel)
+
+	if err := ensureAudioCapture(); err != nil {
+		log.Printf("Failed to start audio capture: %v", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "Audio capture unavailable")
+		return
+	}
+
+	if raw {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", "audio/wav")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if !raw {
+		if _, err := w.Write(wavStreamHeader()); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	log.Printf("HTTP audio stream started for %s", r.RemoteAddr)
+	defer log.Printf("HTTP audio stream ended for %s", r.RemoteAddr)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-audioChannel:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+)
+
+// levelSamplesPerUpdate is how many samples are aggregated per audio-level message (100ms),
+// counted in samples rather than frames so the rate doesn't depend on the frame duration
+const levelSamplesPerUpdate = captureSampleRate * captureChannels / 10
+
+type AudioLevelMessage struct {
+	Type string `json:"type"`
+	Peak int    `json:"peak"` // 0-100
+	RMS  int    `json:"rms"`  // 0-100
+}
+
+// AudioLevelMeter accumulates peak and RMS amplitude over a few PCM frames
+type AudioLevelMeter struct {
+	peak       int
+	sumSquares float64
+	samples    int
+}
+
+// add accumulates one s16le frame and reports whether a full update window is ready
+func (m *AudioLevelMeter) add(frame []byte) bool {
+	for i := 0; i+1 < len(frame); i += 2 {
+		sample := int(int16(frame[i]) | int16(frame[i+1])<<8)
+		if sample < 0 {
+			sample = -sample
+		}
+		if sample > m.peak {
+			m.peak = sample
+		}
+		m.sumSquares += float64(sample * sample)
+	}
+	m.samples += len(frame) / 2
+	return m.samples >= levelSamplesPerUpdate
+}
+
+// levels returns the normalized peak and RMS for the window and resets the meter
+func (m *AudioLevelMeter) levels() (peak, rms int) {
+	if m.samples > 0 {
+		peak = clampPercent(m.peak * 100 / 32768)
+		rms = clampPercent(int(math.Sqrt(m.sumSquares/float64(m.samples)) * 100 / 32768))
+	}
+	*m = AudioLevelMeter{}
+	return peak, rms
+}
+
+// broadcastAudioLevel sends levels to the clients showing the audio tab, the only ones displaying
+// the meter. It never blocks so a busy client never stalls the audio path.
+func broadcastAudioLevel(peak, rms int) {
+	hub := globalHub
+	if hub == nil {
+		return
+	}
+
+	data, err := json.Marshal(AudioLevelMessage{
+		Type: "audio-level",
+		Peak: peak,
+		RMS:  rms,
+	})
+	if err != nil {
+		log.Println("Error marshaling audio level message:", err)
+		return
+	}
+
+	// Sent like the time ticks, the hub only closes send under its write lock
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	for client := range hub.clients {
+		client.mutex.RLock()
+		onAudioTab := client.tab == "audio"
+		client.mutex.RUnlock()
+		if !onAudioTab {
+			continue
+		}
+
+		select {
+		case client.send <- data:
+		default:
+			// Client is busy, it will get the next level
+			client.droppedMessages.Add(1)
+			websocketMessagesDropped.Add(1)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// AudioListenersMessage counts devices listening to the audio, by transport. WebRTC displays
+// share one encoder and MP3 players one ffmpeg, so Multiplexer is the number of subscribers
+// keeping the capture alive rather than the number of devices.
+type AudioListenersMessage struct {
+	Type        string `json:"type"`
+	Listeners   int    `json:"listeners"` // Total devices
+	WebRTC      int    `json:"webrtc"`
+	HTTP        int    `json:"http"`
+	MP3         int    `json:"mp3"`
+	Multiplexer int    `json:"multiplexer"`
+}
+
+// audioListenersChanged coalesces change notifications, the notifier always sends the latest count
+var audioListenersChanged = make(chan struct{}, 1)
+
+func (am *AudioMultiplexer) countByName(name string) int {
+	am.listenersMutex.RLock()
+	defer am.listenersMutex.RUnlock()
+
+	count := 0
+	for _, listener := range am.listeners {
+		if listener.name == name {
+			count++
+		}
+	}
+	return count
+}
+
+func currentAudioListeners() AudioListenersMessage {
+	sharedEncoder.mutex.Lock()
+	webrtcCount := len(sharedEncoder.subscribers)
+	sharedEncoder.mutex.Unlock()
+
+	mp3Streamer.mutex.Lock()
+	mp3Count := len(mp3Streamer.listeners)
+	mp3Streamer.mutex.Unlock()
+
+	httpCount := audioMultiplexer.countByName("http")
+
+	return AudioListenersMessage{
+		Type:        "audio-listeners",
+		Listeners:   webrtcCount + httpCount + mp3Count,
+		WebRTC:      webrtcCount,
+		HTTP:        httpCount,
+		MP3:         mp3Count,
+		Multiplexer: audioMultiplexer.listenerCount(),
+	}
+}
+
+// notifyAudioListeners is called after a subscribe or unsubscribe and never blocks, so it is
+// safe from the audio path
+func notifyAudioListeners() {
+	select {
+	case audioListenersChanged <- struct{}{}:
+	default:
+		// A notification is already pending and will pick up this change
+	}
+}
+
+// runAudioListenersNotifier broadcasts audio-listeners whenever the counts change
+func runAudioListenersNotifier(hub *Hub) {
+	var last AudioListenersMessage
+	for range audioListenersChanged {
+		current := currentAudioListeners()
+		if current == last {
+			continue
+		}
+		last = current
+
+		data, err := json.Marshal(current)
+		if err != nil {
+			log.Println("Error marshaling audio listeners message:", err)
+			continue
+		}
+		hub.tryBroadcast(data, "audio listeners")
+		mqttBridge.publishAudioListeners(current)
+	}
+}
+
+func handleAudioListeners(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentAudioListeners())
+}
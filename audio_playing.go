@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// audioPlayingDebounce is how long the shared stream has to stay playing or silent before clients
+// are told, so gaps between tracks don't make the indicator flicker (AUDIO_PLAYING_DEBOUNCE_MS)
+var audioPlayingDebounce = time.Duration(envInt("AUDIO_PLAYING_DEBOUNCE_MS", 2000)) * time.Millisecond
+
+// AudioPlayingMessage tells clients whether the shared audio is playing or paused for silence
+type AudioPlayingMessage struct {
+	Type    string `json:"type"`
+	Playing bool   `json:"playing"`
+}
+
+// AudioPlayingState debounces the shared encoder's silence detection into the state clients see
+type AudioPlayingState struct {
+	playing    bool // Last state broadcast
+	pending    bool // Latest state reported by the encoder
+	generation int  // Bumped on every report so a superseded timer does nothing
+	mutex      sync.Mutex
+}
+
+var audioPlaying = &AudioPlayingState{}
+
+// set records whether the stream is playing, clients hear of it once it has held for the debounce
+func (ap *AudioPlayingState) set(playing bool) {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+
+	if playing == ap.pending {
+		return
+	}
+	ap.pending = playing
+	ap.generation++
+	if playing == ap.playing {
+		return
+	}
+
+	generation := ap.generation
+	time.AfterFunc(audioPlayingDebounce, func() { ap.settle(generation) })
+}
+
+func (ap *AudioPlayingState) settle(generation int) {
+	ap.mutex.Lock()
+	if generation != ap.generation || ap.pending == ap.playing {
+		ap.mutex.Unlock()
+		return
+	}
+	ap.playing = ap.pending
+	playing := ap.playing
+	ap.mutex.Unlock()
+
+	log.Printf("Shared audio is now %s", map[bool]string{true: "playing", false: "paused"}[playing])
+	if globalHub != nil {
+		broadcastAudioPlaying(globalHub, playing)
+	}
+}
+
+func (ap *AudioPlayingState) get() bool {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+	return ap.playing
+}
+
+func broadcastAudioPlaying(hub *Hub, playing bool) {
+	data, err := json.Marshal(AudioPlayingMessage{Type: "audio-playing", Playing: playing})
+	if err != nil {
+		log.Println("Error marshaling audio playing message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "audio playing")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// AudioEnabledMessage turns the audio stream on or off for the sender or a targeted client
+type AudioEnabledMessage struct {
+	Type     string `json:"type"`
+	Enabled  bool   `json:"enabled"`
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// AudioPreferences remembers which displays have audio disabled. Displays reporting a display ID
+// keep the choice across restarts, it is persisted to a JSON file like the zones. For the others
+// it is keyed by client ID, which lasts as long as resumed reconnects.
+type AudioPreferences struct {
+	disabled map[string]bool // Display IDs with audio off
+	clients  map[string]bool // Client IDs with audio off, for clients without a display ID
+	path     string
+	mutex    sync.RWMutex
+}
+
+var audioPreferences = newAudioPreferences("audio_prefs.json")
+
+func newAudioPreferences(path string) *AudioPreferences {
+	return &AudioPreferences{disabled: make(map[string]bool), clients: make(map[string]bool), path: path}
+}
+
+func (ap *AudioPreferences) enabled(client *Client) bool {
+	ap.mutex.RLock()
+	defer ap.mutex.RUnlock()
+
+	if client.displayID != "" {
+		return !ap.disabled[client.displayID]
+	}
+	return !ap.clients[client.id]
+}
+
+func (ap *AudioPreferences) set(client *Client, enabled bool) {
+	ap.mutex.Lock()
+	defer ap.mutex.Unlock()
+
+	if client.displayID == "" {
+		if enabled {
+			delete(ap.clients, client.id)
+		} else {
+			ap.clients[client.id] = true
+		}
+		return
+	}
+
+	if enabled {
+		delete(ap.disabled, client.displayID)
+	} else {
+		ap.disabled[client.displayID] = true
+	}
+
+	displays := make([]string, 0, len(ap.disabled))
+	for id := range ap.disabled {
+		displays = append(displays, id)
+	}
+	sort.Strings(displays)
+	data, err := json.MarshalIndent(displays, "", "  ")
+	if err != nil {
+		log.Printf("Failed to persist audio preferences: %v", err)
+		return
+	}
+	if err := os.WriteFile(ap.path, data, 0644); err != nil {
+		log.Printf("Failed to persist audio preferences: %v", err)
+	}
+}
+
+// load reads the display IDs with audio disabled
+func (ap *AudioPreferences) load() error {
+	data, err := os.ReadFile(ap.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var displays []string
+	if err := json.Unmarshal(data, &displays); err != nil {
+		return err
+	}
+
+	ap.mutex.Lock()
+	for _, id := range displays {
+		ap.disabled[id] = true
+	}
+	ap.mutex.Unlock()
+	log.Printf("Loaded audio preferences for %d display(s) from %s", len(displays), ap.path)
+	return nil
+}
+
+// startClientAudio starts the encoder goroutine for a connected client unless audio is
+// disabled for it or a stream is already running
+func startClientAudio(client *Client) {
+	if !audioPreferences.enabled(client) {
+		log.Printf("Audio disabled for client %s, not streaming", client.id)
+		return
+	}
+
+	client.mutex.Lock()
+	if client.audioStreaming || client.audioTrack == nil || client.peerConnection == nil ||
+		client.peerConnection.ConnectionState() != webrtc.PeerConnectionStateConnected {
+		client.mutex.Unlock()
+		return
+	}
+	client.audioStreaming = true
+	peerStop := make(chan struct{})
+	client.peerAudioStop = peerStop
+	client.peerAudioConn = client.peerConnection
+	track := client.audioTrack
+	client.mutex.Unlock()
+
+	go func() {
+		streamAudioToTrack(client, track, client.stopAudio, peerStop)
+
+		client.mutex.Lock()
+		client.audioStreaming = false
+		if client.peerAudioStop == peerStop {
+			client.peerAudioStop = nil
+			client.peerAudioConn = nil
+		}
+		client.mutex.Unlock()
+	}()
+}
+
+// stopPeerAudio ends the client's stream if it belongs to peerConnection, so a late state change
+// from a replaced connection can't stop the stream of its successor
+func stopPeerAudio(client *Client, peerConnection *webrtc.PeerConnection) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.peerAudioStop == nil || client.peerAudioConn != peerConnection {
+		return
+	}
+	close(client.peerAudioStop)
+	client.peerAudioStop = nil
+	client.peerAudioConn = nil
+}
+
+func handleAudioEnabledMessage(hub *Hub, client *Client, msg *AudioEnabledMessage) {
+	target := client
+	if msg.ClientID != "" {
+		if target = hub.findClient(msg.ClientID); target == nil {
+			sendError(client, fmt.Sprintf("Client %s not connected", msg.ClientID))
+			return
+		}
+	}
+
+	audioPreferences.set(target, msg.Enabled)
+	log.Printf("Audio for client %s set to %t", target.id, msg.Enabled)
+
+	// Disabling is picked up by the stream on its next frame, enabling needs a new stream
+	if msg.Enabled {
+		startClientAudio(target)
+	}
+
+	data, err := json.Marshal(AudioEnabledMessage{
+		Type:     "audio-enabled-update",
+		Enabled:  msg.Enabled,
+		ClientID: target.id,
+	})
+	if err != nil {
+		log.Println("Error marshaling audio enabled message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "audio enabled")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AudioSource produces the raw capture stream: 48kHz stereo s16le PCM. Each Open starts a new
+// stream, closing it stops whatever produces the audio.
+type AudioSource interface {
+	Open() (io.ReadCloser, error)
+	String() string
+}
+
+// audioSource is parec unless AUDIO_TEST_SOURCE selects a generated one
+var audioSource = newAudioSource()
+
+func newAudioSource() AudioSource {
+	if testAudioSource == "" {
+		return parecSource{}
+	}
+
+	source, err := parseTestAudioSource(testAudioSource)
+	if err != nil {
+		log.Printf("Invalid AUDIO_TEST_SOURCE, capturing with parec: %v", err)
+		return parecSource{}
+	}
+	return source
+}
+
+// parecSource records the configured PulseAudio device
+type parecSource struct{}
+
+func (parecSource) String() string {
+	return "parec"
+}
+
+func (parecSource) Open() (io.ReadCloser, error) {
+	// parec buffers half a frame so reads stay frame aligned
+	settings := audioConfig.get()
+	latency := max(int(settings.FrameDuration/2), 1)
+	cmd := exec.Command("parec",
+		"--format=s16le",
+		fmt.Sprintf("--rate=%d", captureSampleRate),
+		fmt.Sprintf("--channels=%d", captureChannels),
+		fmt.Sprintf("--latency-msec=%d", latency),
+		fmt.Sprintf("--process-time-msec=%d", latency),
+		"--device="+audioConfig.getDevice(),
+	)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &parecStream{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// parecStream is parec's stdout, closing it kills and reaps the process
+type parecStream struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	once sync.Once
+	err  error
+}
+
+func (ps *parecStream) Close() error {
+	ps.once.Do(func() {
+		// The process may already have exited on its own, that isn't a failure to stop it
+		if err := ps.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			ps.err = err
+		}
+		// Wait reaps the process and closes the pipe, which ends the drainer
+		ps.cmd.Wait()
+	})
+	return ps.err
+}
+
+// toneSource generates a sine wave, see newToneReader
+type toneSource struct {
+	frequency float64
+}
+
+func (ts toneSource) String() string {
+	return fmt.Sprintf("a %gHz test tone", ts.frequency)
+}
+
+func (ts toneSource) Open() (io.ReadCloser, error) {
+	return newToneReader(ts.frequency), nil
+}
+
+// wavSource plays a WAV file in a loop
+type wavSource struct {
+	path string
+}
+
+func (ws wavSource) String() string {
+	return ws.path
+}
+
+func (ws wavSource) Open() (io.ReadCloser, error) {
+	return newWAVReader(ws.path)
+}
+
+// parseTestAudioSource reads AUDIO_TEST_SOURCE: "sine", "sine:<hz>" or a WAV file path
+func parseTestAudioSource(spec string) (AudioSource, error) {
+	if spec != "sine" && !strings.HasPrefix(spec, "sine:") {
+		// Fail at startup rather than on the first listener
+		if _, err := readWAV(spec); err != nil {
+			return nil, err
+		}
+		return wavSource{path: spec}, nil
+	}
+
+	frequency := 440.0
+	if value, ok := strings.CutPrefix(spec, "sine:"); ok {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 || parsed >= captureSampleRate/2 {
+			return nil, fmt.Errorf("invalid test tone frequency %q", value)
+		}
+		frequency = parsed
+	}
+	return toneSource{frequency: frequency}, nil
+}
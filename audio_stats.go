@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// streamCounters accumulates what the shared encoder wrote to one track. They are updated from
+// the encoding goroutine and read by the stats handler, hence atomic.
+type streamCounters struct {
+	packets       atomic.Uint64
+	bytes         atomic.Uint64
+	audio         atomic.Int64 // Nanoseconds of audio in the packets written
+	silencePauses atomic.Uint64
+	tabPauses     atomic.Uint64
+}
+
+// StreamStats describes one client's WebRTC audio stream for tuning
+type StreamStats struct {
+	Codec             string    `json:"codec"`
+	Bitrate           int       `json:"bitrate,omitempty"` // Opus target in bits per second, after adaptation
+	Packets           uint64    `json:"packets"`           // Frames encoded for and written to the track
+	Bytes             uint64    `json:"bytes"`
+	AveragePacketSize float64   `json:"averagePacketSize"` // Bytes
+	EncodedBitrate    float64   `json:"encodedBitrate"`    // Bits per second of audio actually sent, pauses excluded
+	SilencePauses     uint64    `json:"silencePauses"`     // Times the stream paused for silence
+	TabPauses         uint64    `json:"tabPauses"`         // Times the stream paused because the client left the audio tab
+	Muted             bool      `json:"muted"`
+	Paused            bool      `json:"paused"` // Off the audio tab right now
+	Since             time.Time `json:"since"`  // When the track subscribed
+}
+
+func (ts *trackSubscriber) recordPacket(size int, duration time.Duration) {
+	ts.stats.packets.Add(1)
+	ts.stats.bytes.Add(uint64(size))
+	ts.stats.audio.Add(int64(duration))
+}
+
+func (ts *trackSubscriber) streamStats(settings AudioSettings) StreamStats {
+	ts.client.mutex.RLock()
+	muted := ts.client.muted
+	onAudioTab := ts.client.tab == "audio"
+	ts.client.mutex.RUnlock()
+
+	stats := StreamStats{
+		Codec:         ts.codec,
+		Packets:       ts.stats.packets.Load(),
+		Bytes:         ts.stats.bytes.Load(),
+		SilencePauses: ts.stats.silencePauses.Load(),
+		TabPauses:     ts.stats.tabPauses.Load(),
+		Muted:         muted,
+		Paused:        audioTabOnly && !onAudioTab,
+		Since:         ts.subscribedAt,
+	}
+	if ts.codec == webrtc.MimeTypeOpus {
+		stats.Bitrate = ts.encoderKey(settings).bitrate
+	}
+	if stats.Packets > 0 {
+		stats.AveragePacketSize = float64(stats.Bytes) / float64(stats.Packets)
+	}
+	if audio := time.Duration(ts.stats.audio.Load()); audio > 0 {
+		stats.EncodedBitrate = float64(stats.Bytes*8) / audio.Seconds()
+	}
+	return stats
+}
+
+func handleAudioStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	settings := audioConfig.get()
+	response := map[string]StreamStats{}
+	for _, subscriber := range sharedEncoder.snapshot() {
+		response[subscriber.client.id] = subscriber.streamStats(settings)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
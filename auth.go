@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// apiToken enables authentication when set. Mutating requests and the WebSocket then need
+// an "Authorization: Bearer <token>" header or a ?token= query parameter.
+var apiToken = os.Getenv("API_TOKEN")
+
+// protectReads extends the token check to GET requests when API_TOKEN_PROTECT_READS=true
+var protectReads = os.Getenv("API_TOKEN_PROTECT_READS") == "true"
+
+func tokenValid(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1
+}
+
+// requireToken rejects every request without a valid token when one is configured
+func requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiToken != "" && !tokenValid(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// protect requires the token for mutating requests, and for reads when protectReads is set
+func protect(next http.HandlerFunc) http.HandlerFunc {
+	checked := requireToken(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !protectReads && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			next(w, r)
+			return
+		}
+		checked(w, r)
+	}
+}
+
+// requireConfiguredToken guards endpoints that control system services: mutating requests are
+// refused entirely unless API_TOKEN is set, and then need the token. Reads behave like protect.
+func requireConfiguredToken(next http.HandlerFunc) http.HandlerFunc {
+	checked := protect(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiToken == "" && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSONError(w, http.StatusForbidden, "Set API_TOKEN to enable this endpoint")
+			return
+		}
+		checked(w, r)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// maxAutoRefreshDelay caps the backoff between auto-refreshes of one display
+const maxAutoRefreshDelay = 5 * time.Minute
+
+var (
+	// autoRefreshDelay is how long WebRTC gets to reconnect on its own before the display is
+	// refreshed (AUTO_REFRESH_DELAY, in seconds). It doubles with each consecutive auto-refresh.
+	autoRefreshDelay = time.Duration(envInt("AUTO_REFRESH_DELAY", 5)) * time.Second
+
+	// autoRefreshWindow is how long a display must stay up after an auto-refresh for the next
+	// disconnect to start over at autoRefreshDelay (AUTO_REFRESH_WINDOW, in seconds)
+	autoRefreshWindow = time.Duration(envInt("AUTO_REFRESH_WINDOW", 600)) * time.Second
+
+	// autoRefreshLimit is how many consecutive auto-refreshes a display gets before it is left
+	// alone as persistently broken (AUTO_REFRESH_LIMIT)
+	autoRefreshLimit = envInt("AUTO_REFRESH_LIMIT", 5)
+)
+
+// handleAutoRefresh refreshes a display whose WebRTC connection dropped and didn't come back.
+// Consecutive refreshes back off, and stop after autoRefreshLimit so a display that can't hold a
+// connection doesn't reload in a loop. The count survives the reload through the resume token.
+func handleAutoRefresh(client *Client) {
+	client.mutex.Lock()
+	if !client.lastAutoRefresh.IsZero() && time.Since(client.lastAutoRefresh) > autoRefreshWindow {
+		client.autoRefreshes = 0
+	}
+	attempts := client.autoRefreshes
+	client.mutex.Unlock()
+
+	if attempts >= autoRefreshLimit {
+		log.Printf("Client %s lost WebRTC again after %d auto-refreshes, it seems persistently broken, not refreshing", client.id, attempts)
+		return
+	}
+
+	// Wait a bit to see if WebRTC reconnects naturally
+	delay := min(autoRefreshDelay<<attempts, maxAutoRefreshDelay)
+	time.Sleep(delay)
+
+	client.mutex.RLock()
+	connected := client.webrtcConnected
+	client.mutex.RUnlock()
+
+	if connected {
+		return
+	}
+
+	log.Printf("WebRTC still disconnected after %s, triggering auto-refresh %d/%d", delay, attempts+1, autoRefreshLimit)
+	if handleRefreshMessage(client, false) {
+		client.mutex.Lock()
+		client.autoRefreshes = attempts + 1
+		client.lastAutoRefresh = time.Now()
+		client.mutex.Unlock()
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Backlight drives a sysfs backlight device such as /sys/class/backlight/rpi_backlight
+type Backlight struct {
+	path          string // Device directory, empty when no backlight is available
+	maxBrightness int
+	once          sync.Once
+	mutex         sync.Mutex
+}
+
+var backlight = &Backlight{}
+
+// findBacklight uses BACKLIGHT_PATH or the first device under /sys/class/backlight
+func findBacklight() string {
+	if path := os.Getenv("BACKLIGHT_PATH"); path != "" {
+		return path
+	}
+
+	matches, _ := filepath.Glob("/sys/class/backlight/*")
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
+
+func readSysfsInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+func (b *Backlight) init() {
+	path := findBacklight()
+	if path == "" {
+		log.Println("No backlight device found, brightness changes won't touch hardware")
+		return
+	}
+
+	maxBrightness, err := readSysfsInt(filepath.Join(path, "max_brightness"))
+	if err != nil {
+		log.Printf("Backlight %s unavailable, brightness changes won't touch hardware: %v", path, err)
+		return
+	}
+
+	b.path = path
+	b.maxBrightness = maxBrightness
+	log.Printf("Using backlight %s (max brightness %d)", path, maxBrightness)
+}
+
+// apply scales a 0-100 brightness onto the panel's range and writes it to sysfs
+func (b *Backlight) apply(brightness int) {
+	b.once.Do(b.init)
+	if b.path == "" {
+		return
+	}
+
+	value := clampPercent(brightness) * b.maxBrightness / 100
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := os.WriteFile(filepath.Join(b.path, "brightness"), []byte(fmt.Sprintf("%d\n", value)), 0644); err != nil {
+		log.Printf("Failed to write backlight brightness: %v", err)
+	}
+}
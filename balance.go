@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// BalanceState is the stereo balance of the WebRTC stream, -100 (left only) to 100 (right only).
+// Mono streams ignore it.
+type BalanceState struct {
+	value int
+	mutex sync.RWMutex
+}
+
+var balanceState = &BalanceState{}
+
+type BalanceMessage struct {
+	Type    string `json:"type"`
+	Balance int    `json:"balance"`
+}
+
+// balanceGains maps a balance onto per-channel multipliers, attenuating only the far channel so
+// the centre position leaves the audio unchanged
+func balanceGains(balance int) (left, right float64) {
+	left, right = 1, 1
+	if balance > 0 {
+		left = 1 - float64(balance)/100
+	} else if balance < 0 {
+		right = 1 + float64(balance)/100
+	}
+	return left, right
+}
+
+// setBalance clamps and stores the balance, returning the value actually applied
+func setBalance(balance int) int {
+	balance = min(max(balance, -100), 100)
+
+	balanceState.mutex.Lock()
+	balanceState.value = balance
+	balanceState.mutex.Unlock()
+
+	return balance
+}
+
+func currentBalance() int {
+	balanceState.mutex.RLock()
+	defer balanceState.mutex.RUnlock()
+	return balanceState.value
+}
+
+func handleBalanceMessage(hub *Hub, client *Client, msg *BalanceMessage) {
+	switch msg.Type {
+	case "set-balance":
+		balance := setBalance(msg.Balance)
+		log.Printf("Balance set to %d", balance)
+
+		// Broadcast balance update to all clients
+		broadcastBalance(hub, balance)
+	case "get-balance":
+		sendBalance(client, currentBalance())
+	}
+}
+
+func broadcastBalance(hub *Hub, balance int) {
+	data, err := json.Marshal(BalanceMessage{
+		Type:    "balance-update",
+		Balance: balance,
+	})
+	if err != nil {
+		log.Println("Error marshaling balance message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "balance")
+}
+
+func sendBalance(client *Client, balance int) {
+	data, err := json.Marshal(BalanceMessage{
+		Type:    "balance-update",
+		Balance: balance,
+	})
+	if err != nil {
+		log.Println("Error marshaling balance message:", err)
+		return
+	}
+
+	sendToClient(client, data)
+}
+
+func handleBalance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Balance int `json:"balance"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		balance := setBalance(req.Balance)
+		log.Printf("Balance set to %d via HTTP", balance)
+
+		// Broadcast balance update to all WebSocket clients
+		if globalHub != nil {
+			broadcastBalance(globalHub, balance)
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string]int{"balance": currentBalance()}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBannerDuration is how long a banner stays up when no duration is given
+const defaultBannerDuration = 5 * time.Minute
+
+// maxBannerLength keeps announcements short enough to fit the display
+const maxBannerLength = 200
+
+// bannerPriorities are the accepted priorities, lowest first
+var bannerPriorities = []string{"low", "normal", "high", "critical"}
+
+// Banner is an announcement shown on every display until it expires
+type Banner struct {
+	Text      string    `json:"text"`
+	Priority  string    `json:"priority"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// BannerMessage is set-banner from clients and banner-update / clear-banner from the server
+type BannerMessage struct {
+	Type      string     `json:"type"`
+	Text      string     `json:"text,omitempty"`
+	Priority  string     `json:"priority,omitempty"`
+	Duration  int        `json:"duration,omitempty"` // Seconds until it clears, for set-banner
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// MessageBanner holds the current banner and the timer that clears it
+type MessageBanner struct {
+	banner *Banner
+	expiry *time.Timer
+	mutex  sync.Mutex
+}
+
+var messageBanner = &MessageBanner{}
+
+func bannerPriorityRank(priority string) int {
+	for i, known := range bannerPriorities {
+		if priority == known {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateBanner fills in the default priority and checks the fields of a set request
+func validateBanner(msg *BannerMessage) error {
+	msg.Text = strings.TrimSpace(msg.Text)
+	if len(msg.Text) > maxBannerLength {
+		return fmt.Errorf("text must be at most %d characters", maxBannerLength)
+	}
+	if msg.Priority == "" {
+		msg.Priority = "normal"
+	}
+	if bannerPriorityRank(msg.Priority) < 0 {
+		return fmt.Errorf("priority must be one of: %s", strings.Join(bannerPriorities, ", "))
+	}
+	if msg.Duration < 0 {
+		return fmt.Errorf("duration must be a positive number of seconds")
+	}
+	return nil
+}
+
+func (mb *MessageBanner) get() *Banner {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	if mb.banner == nil {
+		return nil
+	}
+	banner := *mb.banner
+	return &banner
+}
+
+// set shows a banner, replacing any current one. Empty text clears it. It reports false when
+// quiet hours hold the banner back.
+func (mb *MessageBanner) set(hub *Hub, msg *BannerMessage) bool {
+	if msg.Text == "" {
+		mb.clear(hub, nil)
+		return true
+	}
+
+	if quietHours.suppressesBanner(msg.Priority, time.Now().In(defaultLocation())) {
+		log.Printf("Banner suppressed by quiet hours (%s): %s", msg.Priority, msg.Text)
+		return false
+	}
+
+	duration := defaultBannerDuration
+	if msg.Duration > 0 {
+		duration = time.Duration(msg.Duration) * time.Second
+	}
+	banner := &Banner{
+		Text:      msg.Text,
+		Priority:  msg.Priority,
+		ExpiresAt: time.Now().Add(duration),
+	}
+
+	mb.mutex.Lock()
+	if mb.expiry != nil {
+		mb.expiry.Stop()
+	}
+	mb.banner = banner
+	mb.expiry = time.AfterFunc(duration, func() {
+		log.Println("Banner expired")
+		mb.clear(hub, banner)
+	})
+	mb.mutex.Unlock()
+
+	log.Printf("Banner set (%s, %s): %s", banner.Priority, duration, banner.Text)
+	expiresAt := banner.ExpiresAt
+	broadcastBanner(hub, BannerMessage{
+		Type:      "banner-update",
+		Text:      banner.Text,
+		Priority:  banner.Priority,
+		ExpiresAt: &expiresAt,
+	})
+	return true
+}
+
+// clear removes the banner. With a non-nil only it is a no-op unless that banner is still
+// current, so an expiry timer that fires late can't clear its replacement.
+func (mb *MessageBanner) clear(hub *Hub, only *Banner) {
+	mb.mutex.Lock()
+	if mb.banner == nil || (only != nil && mb.banner != only) {
+		mb.mutex.Unlock()
+		return
+	}
+	mb.banner = nil
+	if mb.expiry != nil {
+		mb.expiry.Stop()
+		mb.expiry = nil
+	}
+	mb.mutex.Unlock()
+
+	broadcastBanner(hub, BannerMessage{Type: "clear-banner"})
+}
+
+func broadcastBanner(hub *Hub, msg BannerMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling banner message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "banner")
+}
+
+func handleBannerMessage(hub *Hub, client *Client, msg *BannerMessage) {
+	if err := validateBanner(msg); err != nil {
+		sendError(client, "Invalid banner: "+err.Error())
+		return
+	}
+	if !messageBanner.set(hub, msg) {
+		sendError(client, "Banner suppressed by quiet hours, use a higher priority")
+	}
+}
+
+func handleBanner(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var msg BannerMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := validateBanner(&msg); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if globalHub != nil && !messageBanner.set(globalHub, &msg) {
+			writeJSONError(w, http.StatusConflict, "Banner suppressed by quiet hours, use a higher priority")
+			return
+		}
+		log.Println("Banner updated via HTTP")
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string]interface{}{"banner": messageBanner.get()}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
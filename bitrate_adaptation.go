@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/pion/rtcp"
+)
+
+// bitrateAdaptation lowers a client's Opus bitrate when its receiver reports loss or a low
+// bandwidth estimate, so weak links get a smaller stream instead of choppy audio.
+// BITRATE_ADAPTATION=false streams the configured bitrate to everyone.
+var bitrateAdaptation = os.Getenv("BITRATE_ADAPTATION") != "false"
+
+const (
+	adaptiveStartBitrate = 32000 // Where a new connection starts before any feedback
+	adaptiveMinBitrate   = 12000
+	lossBackoffThreshold = 0.10 // Fraction lost above which the bitrate drops
+	lossRampThreshold    = 0.02 // Fraction lost below which the bitrate grows
+	bitrateRampUp        = 1.1  // Growth per clean receiver report
+)
+
+// adaptiveBitrateSteps are the bitrates clients are snapped to. Clients on the same step share an
+// encoder, so their number bounds how many Opus encoders can run.
+var adaptiveBitrateSteps = []int{12000, 16000, 24000, 32000, 48000, 64000, 96000, 128000, 192000, 256000, 384000}
+
+// BitrateAdapter estimates the bitrate a client's link sustains from its RTCP feedback. It starts
+// conservative and ramps up while reports come back clean.
+type BitrateAdapter struct {
+	estimate int // Loss-based estimate in bits per second
+	remb     int // Receiver's bandwidth estimate in bits per second, 0 until one arrives
+	mutex    sync.Mutex
+}
+
+func newBitrateAdapter() *BitrateAdapter {
+	return &BitrateAdapter{estimate: adaptiveStartBitrate}
+}
+
+// handleRTCP updates the estimate from Receiver Reports and REMB packets, ignoring the rest
+func (ba *BitrateAdapter) handleRTCP(clientID string, packets []rtcp.Packet) {
+	ceiling := audioConfig.get().Bitrate
+	for _, packet := range packets {
+		switch packet := packet.(type) {
+		case *rtcp.ReceiverReport:
+			for _, report := range packet.Reports {
+				ba.reportLoss(clientID, float64(report.FractionLost)/256, ceiling)
+			}
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			ba.mutex.Lock()
+			ba.remb = int(packet.Bitrate)
+			ba.mutex.Unlock()
+		}
+	}
+}
+
+func (ba *BitrateAdapter) reportLoss(clientID string, loss float64, ceiling int) {
+	before := ba.target(ceiling)
+
+	ba.mutex.Lock()
+	switch {
+	case loss > lossBackoffThreshold:
+		ba.estimate = int(float64(ba.estimate) * (1 - loss/2))
+	case loss < lossRampThreshold:
+		ba.estimate = int(float64(ba.estimate) * bitrateRampUp)
+	}
+	// Capped at the ceiling so a clean link doesn't build up an estimate it never streamed at
+	ba.estimate = min(max(ba.estimate, adaptiveMinBitrate), max(ceiling, adaptiveMinBitrate))
+	ba.mutex.Unlock()
+
+	if after := ba.target(ceiling); after != before {
+		log.Printf("Client %s bitrate %d -> %d bps (%.0f%% loss)", clientID, before, after, loss*100)
+	}
+}
+
+// target is the bitrate to encode for this client at most at ceiling, the configured bitrate.
+// A nil adapter, or adaptation being off, streams the ceiling.
+func (ba *BitrateAdapter) target(ceiling int) int {
+	if ba == nil || !bitrateAdaptation {
+		return ceiling
+	}
+
+	ba.mutex.Lock()
+	bitrate := ba.estimate
+	if ba.remb > 0 {
+		bitrate = min(bitrate, ba.remb)
+	}
+	ba.mutex.Unlock()
+
+	if bitrate >= ceiling {
+		return ceiling
+	}
+	step := adaptiveBitrateSteps[0]
+	for _, candidate := range adaptiveBitrateSteps {
+		if candidate <= bitrate {
+			step = candidate
+		}
+	}
+	return min(step, ceiling)
+}
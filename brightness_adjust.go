@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// adjustBrightness moves the shared brightness by a signed delta, clamped to 0-100, and returns
+// the result. The read and write happen under one lock so concurrent knobs and controllers can't
+// lose each other's steps. A fade in progress is cancelled and the delta applies to where it got.
+func adjustBrightness(hub *Hub, delta int, source string) int {
+	brightnessSchedule.noteManualChange()
+	brightnessFader.stop()
+
+	brightnessState.mutex.Lock()
+	brightness := min(max(brightnessState.value+delta, 0), 100)
+	brightnessState.value = brightness
+	brightnessState.mutex.Unlock()
+
+	backlight.apply(brightness)
+	if hub != nil {
+		broadcastBrightness(hub, brightness, source)
+	}
+	return brightness
+}
+
+func handleAdjustBrightness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Delta int `json:"delta"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	brightness := adjustBrightness(globalHub, req.Delta, updateSourceServer)
+	log.Printf("Brightness adjusted by %+d to %d via HTTP", req.Delta, brightness)
+	brightnessHistory.record(BrightnessChange{Brightness: brightness, Source: brightnessSourceHTTP})
+
+	response := map[string]int{"brightness": brightness}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
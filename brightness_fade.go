@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// fadeStepInterval is the time between intermediate brightness updates during a fade
+const fadeStepInterval = 50 * time.Millisecond
+
+// BrightnessFader ramps the shared brightness over time, one fade at a time
+type BrightnessFader struct {
+	cancel chan struct{}
+	mutex  sync.Mutex
+}
+
+var brightnessFader = &BrightnessFader{}
+
+// stop cancels the fade in progress, if any
+func (f *BrightnessFader) stop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.cancel != nil {
+		close(f.cancel)
+		f.cancel = nil
+	}
+}
+
+// start replaces any running fade with one from the current brightness to target. Every step is
+// broadcast with the given source.
+func (f *BrightnessFader) start(hub *Hub, target int, duration time.Duration, source string) {
+	f.mutex.Lock()
+	if f.cancel != nil {
+		close(f.cancel)
+	}
+	cancel := make(chan struct{})
+	f.cancel = cancel
+	f.mutex.Unlock()
+
+	brightnessState.mutex.RLock()
+	from := brightnessState.value
+	brightnessState.mutex.RUnlock()
+
+	go f.run(hub, cancel, from, target, duration, source)
+}
+
+func (f *BrightnessFader) run(hub *Hub, cancel chan struct{}, from, target int, duration time.Duration, source string) {
+	ticker := time.NewTicker(fadeStepInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	last := from
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+		}
+
+		elapsed := time.Since(start)
+		value := target
+		if elapsed < duration {
+			value = from + int(float64(target-from)*float64(elapsed)/float64(duration))
+		}
+
+		if value != last {
+			setBrightness(value)
+			broadcastBrightness(hub, value, source)
+			last = value
+		}
+
+		if value == target {
+			break
+		}
+	}
+
+	f.mutex.Lock()
+	if f.cancel == cancel {
+		f.cancel = nil
+	}
+	f.mutex.Unlock()
+}
+
+// changeBrightness sets the shared brightness instantly, or fades to it when a duration is given
+func changeBrightness(hub *Hub, brightness int, duration time.Duration, source string) {
+	brightnessSchedule.noteManualChange()
+
+	if duration > 0 {
+		brightnessFader.start(hub, brightness, duration, source)
+		return
+	}
+
+	brightnessFader.stop()
+	setBrightness(brightness)
+
+	// Broadcast brightness update to all clients
+	broadcastBrightness(hub, brightness, source)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Sources of a brightness change, as recorded in the history
+const (
+	brightnessSourceWebSocket = "websocket"
+	brightnessSourceHTTP      = "http"
+	brightnessSourceSchedule  = "schedule"
+	brightnessSourceSensor    = "sensor"
+	brightnessSourceMQTT      = "mqtt"
+)
+
+// BrightnessChange is one entry of the brightness history. Fades are recorded once, with the
+// brightness they end at.
+type BrightnessChange struct {
+	Time       time.Time `json:"time"`
+	Brightness int       `json:"brightness"`
+	Source     string    `json:"source"`             // websocket, http, schedule, sensor or mqtt
+	ClientID   string    `json:"clientId,omitempty"` // WebSocket client that made the change
+	Target     string    `json:"target,omitempty"`   // Client a targeted change applied to, empty for the shared brightness
+	Zone       string    `json:"zone,omitempty"`     // Zone a targeted change applied to
+	Fade       int       `json:"fade,omitempty"`     // Milliseconds
+}
+
+// BrightnessHistory is a ring buffer of the latest brightness changes, for finding out which of
+// the manual controls, schedule and light sensor won (BRIGHTNESS_HISTORY_SIZE entries)
+type BrightnessHistory struct {
+	entries []BrightnessChange
+	next    int // Index the next change is written to
+	full    bool
+	mutex   sync.Mutex
+}
+
+var brightnessHistory = &BrightnessHistory{entries: make([]BrightnessChange, envInt("BRIGHTNESS_HISTORY_SIZE", 100))}
+
+func (bh *BrightnessHistory) record(change BrightnessChange) {
+	change.Time = time.Now()
+
+	bh.mutex.Lock()
+	defer bh.mutex.Unlock()
+
+	bh.entries[bh.next] = change
+	bh.next = (bh.next + 1) % len(bh.entries)
+	if bh.next == 0 {
+		bh.full = true
+	}
+}
+
+// list returns the recorded changes, oldest first
+func (bh *BrightnessHistory) list() []BrightnessChange {
+	bh.mutex.Lock()
+	defer bh.mutex.Unlock()
+
+	if !bh.full {
+		return append([]BrightnessChange{}, bh.entries[:bh.next]...)
+	}
+	return append(append([]BrightnessChange{}, bh.entries[bh.next:]...), bh.entries[:bh.next]...)
+}
+
+func handleBrightnessHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string]interface{}{
+		"history":  brightnessHistory.list(),
+		"capacity": len(brightnessHistory.entries),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
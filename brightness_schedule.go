@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manualOverrideWindow is how long a manual brightness change holds off the schedule
+const manualOverrideWindow = 5 * time.Minute
+
+// ScheduleEntry sets the brightness from a time of day until the next entry
+type ScheduleEntry struct {
+	Time       string `json:"time"` // Time of day, "15:04"
+	Brightness int    `json:"brightness"`
+}
+
+// BrightnessSchedule applies brightness levels at set times of day
+type BrightnessSchedule struct {
+	entries    []ScheduleEntry // Sorted by time
+	lastManual time.Time
+	applied    string // Time of the entry last applied, so each one fires once when crossed
+	mutex      sync.Mutex
+}
+
+var brightnessSchedule = &BrightnessSchedule{}
+
+func (bs *BrightnessSchedule) set(entries []ScheduleEntry) error {
+	for _, entry := range entries {
+		if _, err := time.Parse("15:04", entry.Time); err != nil {
+			return fmt.Errorf("time %q must be in HH:MM format", entry.Time)
+		}
+		if entry.Brightness < 0 || entry.Brightness > 100 {
+			return fmt.Errorf("brightness must be between 0 and 100")
+		}
+	}
+
+	sorted := append([]ScheduleEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+
+	bs.mutex.Lock()
+	bs.entries = sorted
+	bs.applied = ""
+	bs.mutex.Unlock()
+	return nil
+}
+
+func (bs *BrightnessSchedule) get() []ScheduleEntry {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	return append([]ScheduleEntry{}, bs.entries...)
+}
+
+// noteManualChange records that a user picked the brightness, pausing the schedule for a while
+func (bs *BrightnessSchedule) noteManualChange() {
+	bs.mutex.Lock()
+	bs.lastManual = time.Now()
+	bs.mutex.Unlock()
+}
+
+// manualOverrideActive reports whether a manual change is still holding off automatic brightness
+func (bs *BrightnessSchedule) manualOverrideActive() bool {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	return time.Since(bs.lastManual) < manualOverrideWindow
+}
+
+// activeEntry returns the entry in effect at the given minute. Before the first entry
+// of the day the last one still applies, which handles schedules wrapping past midnight.
+func activeEntry(entries []ScheduleEntry, minute string) ScheduleEntry {
+	active := entries[len(entries)-1]
+	for _, entry := range entries {
+		if entry.Time <= minute {
+			active = entry
+		}
+	}
+	return active
+}
+
+// due returns the entry to apply now, if a new one has been crossed since the last check
+func (bs *BrightnessSchedule) due(now time.Time) (ScheduleEntry, bool) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	if len(bs.entries) == 0 {
+		return ScheduleEntry{}, false
+	}
+
+	entry := activeEntry(bs.entries, now.Format("15:04"))
+	if entry.Time == bs.applied {
+		return ScheduleEntry{}, false
+	}
+	bs.applied = entry.Time
+
+	if time.Since(bs.lastManual) < manualOverrideWindow {
+		log.Printf("Skipping scheduled brightness %d at %s, manually changed recently", entry.Brightness, entry.Time)
+		return ScheduleEntry{}, false
+	}
+	return entry, true
+}
+
+// runBrightnessSchedule checks the schedule every minute
+func runBrightnessSchedule(hub *Hub) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		if entry, ok := brightnessSchedule.due(time.Now().In(defaultLocation())); ok {
+			log.Printf("Scheduled brightness %d applied (%s)", entry.Brightness, entry.Time)
+			brightnessHistory.record(BrightnessChange{Brightness: entry.Brightness, Source: brightnessSourceSchedule})
+			brightnessFader.stop()
+			setBrightness(entry.Brightness)
+			broadcastBrightness(hub, entry.Brightness, updateSourceServer)
+		}
+		<-ticker.C
+	}
+}
+
+func handleBrightnessSchedule(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Entries []ScheduleEntry `json:"entries"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := brightnessSchedule.set(req.Entries); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Brightness schedule set with %d entries via HTTP", len(req.Entries))
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string][]ScheduleEntry{"entries": brightnessSchedule.get()}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
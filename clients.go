@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// displayIDPattern limits the display IDs clients report, e.g. "kitchen" or a random token
+var displayIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// displayIDFromRequest returns the stable ID a display connects with (/ws?display=). Unlike the
+// client ID it is the same after a server restart. Invalid IDs are ignored.
+func displayIDFromRequest(r *http.Request) string {
+	id := r.URL.Query().Get("display")
+	if id != "" && !displayIDPattern.MatchString(id) {
+		log.Printf("Ignoring invalid display ID %q", id)
+		return ""
+	}
+	return id
+}
+
+// ClientInfo describes a connected client for the clients endpoint
+type ClientInfo struct {
+	ID              string    `json:"id"`
+	DisplayID       string    `json:"displayId,omitempty"`
+	ConnectedAt     time.Time `json:"connectedAt"`
+	WebRTCConnected bool      `json:"webrtcConnected"`
+	WebRTCState     string    `json:"webrtcState,omitempty"`
+	Tab             string    `json:"tab"`
+	Zone            string    `json:"zone,omitempty"`
+	DroppedMessages uint64    `json:"droppedMessages"`
+}
+
+func (c *Client) info() ClientInfo {
+	c.mutex.RLock()
+	info := ClientInfo{
+		ID:              c.id,
+		DisplayID:       c.displayID,
+		ConnectedAt:     c.connectedAt,
+		WebRTCConnected: c.webrtcConnected,
+		Tab:             c.tab,
+		DroppedMessages: c.droppedMessages.Load(),
+	}
+	c.mutex.RUnlock()
+	info.Zone = zoneManager.zoneOf(c.displayID)
+
+	if c.peerConnection != nil {
+		info.WebRTCState = c.peerConnection.ConnectionState().String()
+	}
+	return info
+}
+
+func handleClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	clients := []ClientInfo{}
+	if globalHub != nil {
+		globalHub.mutex.RLock()
+		for client := range globalHub.clients {
+			clients = append(clients, client.info())
+		}
+		globalHub.mutex.RUnlock()
+	}
+
+	// Oldest connection first
+	sort.Slice(clients, func(i, j int) bool {
+		a, _ := strconv.ParseUint(clients[i].ID, 10, 64)
+		b, _ := strconv.ParseUint(clients[j].ID, 10, 64)
+		return a < b
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clients)
+}
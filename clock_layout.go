@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Layouts of the time and date in time messages when the config leaves them unset
+const (
+	defaultTimeLayout    = "15:04:05"
+	defaultTimeLayout12h = "3:04:05 PM"
+	defaultDateLayout    = "Monday, January 2, 2006"
+)
+
+// maxLayoutLength keeps layouts to what fits on a clock face
+const maxLayoutLength = 64
+
+// ClockLayout sets how time messages format the time and date, as Go reference-time layouts.
+// Empty fields keep the defaults.
+type ClockLayout struct {
+	Time    string `json:"timeLayout"`    // 24h format, default 15:04:05
+	Time12h string `json:"timeLayout12h"` // 12h format, default 3:04:05 PM
+	Date    string `json:"dateLayout"`    // Default Monday, January 2, 2006
+	Locale  string `json:"locale"`        // Language of month and day names, default en
+}
+
+// clockLocale names the days (from Sunday, like time.Weekday) and months in one language
+type clockLocale struct {
+	days        [7]string
+	shortDays   [7]string
+	months      [12]string
+	shortMonths [12]string
+}
+
+// clockLocales are the languages dates can be written in besides English. Go only formats English
+// names, so the formatted ones are swapped for these.
+var clockLocales = map[string]clockLocale{
+	"de": {
+		days:        [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		shortDays:   [7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+		months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		shortMonths: [12]string{"Jan", "Feb", "Mär", "Apr", "Mai", "Jun", "Jul", "Aug", "Sep", "Okt", "Nov", "Dez"},
+	},
+	"es": {
+		days:        [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		shortDays:   [7]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+		months:      [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		shortMonths: [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sep", "oct", "nov", "dic"},
+	},
+	"fr": {
+		days:        [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		shortDays:   [7]string{"dim", "lun", "mar", "mer", "jeu", "ven", "sam"},
+		months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		shortMonths: [12]string{"janv", "févr", "mars", "avr", "mai", "juin", "juil", "août", "sept", "oct", "nov", "déc"},
+	},
+	"it": {
+		days:        [7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		shortDays:   [7]string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"},
+		months:      [12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		shortMonths: [12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+	},
+	"nl": {
+		days:        [7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		shortDays:   [7]string{"zo", "ma", "di", "wo", "do", "vr", "za"},
+		months:      [12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		shortMonths: [12]string{"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+	},
+	"pt": {
+		days:        [7]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"},
+		shortDays:   [7]string{"dom", "seg", "ter", "qua", "qui", "sex", "sáb"},
+		months:      [12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		shortMonths: [12]string{"jan", "fev", "mar", "abr", "mai", "jun", "jul", "ago", "set", "out", "nov", "dez"},
+	},
+}
+
+// clockLocaleNames lists the accepted locales, for error messages
+func clockLocaleNames() []string {
+	names := []string{"en"}
+	for name := range clockLocales {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// validateLayout rejects layouts that format every time the same, i.e. that contain no layout
+// element, by formatting a second time that differs from the reference in every field
+func validateLayout(field, layout string) error {
+	if layout == "" {
+		return nil
+	}
+	if len(layout) > maxLayoutLength {
+		return fmt.Errorf("%s must be at most %d characters", field, maxLayoutLength)
+	}
+
+	reference := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	other := time.Date(2017, time.November, 23, 9, 41, 37, 0, time.UTC)
+	if reference.Format(layout) == other.Format(layout) {
+		return fmt.Errorf("%s %q has no time or date element, see https://pkg.go.dev/time#Layout", field, layout)
+	}
+	return nil
+}
+
+func (l ClockLayout) validate() error {
+	if err := validateLayout("timeLayout", l.Time); err != nil {
+		return err
+	}
+	if err := validateLayout("timeLayout12h", l.Time12h); err != nil {
+		return err
+	}
+	if err := validateLayout("dateLayout", l.Date); err != nil {
+		return err
+	}
+	if _, ok := clockLocales[l.Locale]; !ok && l.Locale != "" && l.Locale != "en" {
+		return fmt.Errorf("locale must be one of: %s", strings.Join(clockLocaleNames(), ", "))
+	}
+	return nil
+}
+
+// format writes t with layout, falling back to the default layout when it is unset, and
+// translates the month and day names of t into the locale
+func (l ClockLayout) format(t time.Time, layout, fallback string) string {
+	if layout == "" {
+		layout = fallback
+	}
+	formatted := t.Format(layout)
+
+	locale, ok := clockLocales[l.Locale]
+	if !ok {
+		return formatted
+	}
+	// Full names come first so the replacer matches them ahead of their abbreviations
+	day, month := t.Weekday(), t.Month()
+	return strings.NewReplacer(
+		day.String(), locale.days[day],
+		month.String(), locale.months[month-1],
+		day.String()[:3], locale.shortDays[day],
+		month.String()[:3], locale.shortMonths[month-1],
+	).Replace(formatted)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ServerConfig is the writable part of the server configuration. Once saved it takes precedence
+// over the TZ and REFRESH_COOLDOWN env vars at startup.
+type ServerConfig struct {
+	Timezone          string        `json:"timezone"`          // IANA zone of the server clock
+	DefaultBrightness int           `json:"defaultBrightness"` // Brightness applied at startup, 0-100
+	RefreshCooldown   int           `json:"refreshCooldown"`   // Default seconds between refreshes of a display
+	Clock             ClockLayout   `json:"clock"`
+	Audio             AudioSettings `json:"audio"`
+}
+
+// configResponse adds the read-only settings to the ServerConfig for GET /api/config
+type configResponse struct {
+	ServerConfig
+	Tabs     []string       `json:"tabs"` // Valid tab names, registered through /api/tabs
+	Protocol map[string]int `json:"protocol"`
+}
+
+// ConfigStore owns the server timezone, clock layout and startup brightness, and persists them to
+// a JSON file along with the settings kept by refreshCooldownState and audioConfig
+type ConfigStore struct {
+	timezone          string
+	location          *time.Location
+	defaultBrightness int
+	clock             ClockLayout
+	path              string
+	mutex             sync.RWMutex
+}
+
+var serverConfig = &ConfigStore{timezone: "UTC", location: time.UTC, defaultBrightness: 50, path: "config.json"}
+
+// newConfigStore starts from the TZ env var, falling back to UTC
+func newConfigStore(path string) *ConfigStore {
+	cs := &ConfigStore{timezone: "UTC", location: time.UTC, defaultBrightness: 50, path: path}
+	if timezone := os.Getenv("TZ"); timezone != "" {
+		if loc, err := time.LoadLocation(timezone); err == nil {
+			cs.timezone = timezone
+			cs.location = loc
+		} else {
+			log.Printf("Invalid TZ %q, falling back to UTC: %v", timezone, err)
+		}
+	}
+	return cs
+}
+
+func (c ServerConfig) validate() error {
+	if c.Timezone == "" {
+		return fmt.Errorf("timezone is required")
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("timezone %q is not a valid IANA zone", c.Timezone)
+	}
+	if c.DefaultBrightness < 0 || c.DefaultBrightness > 100 {
+		return fmt.Errorf("defaultBrightness must be between 0 and 100")
+	}
+	if c.RefreshCooldown < 0 {
+		return fmt.Errorf("refreshCooldown must be zero or a positive number of seconds")
+	}
+	if err := c.Clock.validate(); err != nil {
+		return fmt.Errorf("clock: %v", err)
+	}
+	if err := c.Audio.validate(); err != nil {
+		return fmt.Errorf("audio: %v", err)
+	}
+	return nil
+}
+
+func (cs *ConfigStore) get() ServerConfig {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return ServerConfig{
+		Timezone:          cs.timezone,
+		DefaultBrightness: cs.defaultBrightness,
+		RefreshCooldown:   int(refreshCooldownState.get() / time.Second),
+		Clock:             cs.clock,
+		Audio:             audioConfig.get(),
+	}
+}
+
+func (cs *ConfigStore) getLocation() *time.Location {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.location
+}
+
+func (cs *ConfigStore) clockLayout() ClockLayout {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.clock
+}
+
+// apply hands the settings to the subsystems that own them, the config must be valid
+func (cs *ConfigStore) apply(config ServerConfig) error {
+	loc, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		return err
+	}
+	if err := audioConfig.set(config.Audio); err != nil {
+		return err
+	}
+	refreshCooldownState.set(time.Duration(config.RefreshCooldown) * time.Second)
+
+	cs.mutex.Lock()
+	cs.timezone = config.Timezone
+	cs.location = loc
+	cs.defaultBrightness = config.DefaultBrightness
+	cs.clock = config.Clock
+	cs.mutex.Unlock()
+	return nil
+}
+
+// set validates every field before applying any, so a rejected update changes nothing
+func (cs *ConfigStore) set(config ServerConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if err := cs.apply(config); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(cs.path, data, 0644); err != nil {
+		log.Printf("Failed to persist config: %v", err)
+	}
+	return nil
+}
+
+// load applies the saved config, including the startup brightness
+func (cs *ConfigStore) load() error {
+	data, err := os.ReadFile(cs.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	config := cs.get()
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if err := cs.apply(config); err != nil {
+		return err
+	}
+
+	setBrightness(config.DefaultBrightness)
+	log.Printf("Loaded config (timezone %s, brightness %d) from %s", config.Timezone, config.DefaultBrightness, cs.path)
+	return nil
+}
+
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// Start from the active config so omitted fields are left unchanged
+		config := serverConfig.get()
+		previous := config.Audio
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := serverConfig.set(config); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Config updated via HTTP: %+v", config)
+
+		// The frame size is fixed when parec starts, so a new one needs a fresh capture process
+		if config.Audio.FrameDuration != previous.FrameDuration {
+			if err := restartAudioCapture(); err != nil {
+				writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to restart audio capture: %v", err))
+				return
+			}
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := configResponse{
+		ServerConfig: serverConfig.get(),
+		Tabs:         tabRegistry.list(),
+		Protocol:     map[string]int{"min": minProtocolVersion, "max": maxProtocolVersion},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
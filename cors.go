@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// corsOrigins lists the origins allowed to call /api/* from a browser (CORS_ALLOWED_ORIGINS,
+// comma-separated, "*" for any). Empty keeps CORS disabled, so only same-origin pages can.
+var corsOrigins = parseCORSOrigins(os.Getenv("CORS_ALLOWED_ORIGINS"))
+
+const (
+	corsAllowMethods = "GET, POST, DELETE, OPTIONS"
+	corsAllowHeaders = "Authorization, Content-Type"
+	corsMaxAge       = "600" // Seconds browsers may cache a preflight
+)
+
+func parseCORSOrigins(value string) map[string]bool {
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+func corsOriginAllowed(origin string) bool {
+	return origin != "" && (corsOrigins["*"] || corsOrigins[origin])
+}
+
+// withCORS adds CORS headers to API responses for allowed origins and answers preflight
+// requests itself, before the token check, since browsers never send credentials on them
+func withCORS(next http.Handler) http.Handler {
+	if len(corsOrigins) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !strings.HasPrefix(r.URL.Path, "/api/") || !corsOriginAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			header.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			header.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			header.Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
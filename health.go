@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// hubHeartbeatInterval is how often the hub loop records that it's alive
+	hubHeartbeatInterval = 1 * time.Second
+	// readinessWindow is how recent the last hub heartbeat and audio frame must be
+	readinessWindow = 5 * time.Second
+)
+
+// lastAudioFrame holds the UnixNano time of the last PCM frame read from the capture process
+var lastAudioFrame atomic.Int64
+
+func since(unixNano int64) time.Duration {
+	if unixNano == 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Since(time.Unix(0, unixNano))
+}
+
+type ComponentStatus struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+func hubStatus() ComponentStatus {
+	if globalHub == nil || since(globalHub.lastHeartbeat.Load()) > readinessWindow {
+		return ComponentStatus{OK: false, Message: "hub loop not running"}
+	}
+	return ComponentStatus{OK: true, Message: "running"}
+}
+
+func audioStatus() ComponentStatus {
+	// Capture is stopped on purpose while nobody listens
+	if audioMultiplexer.listenerCount() == 0 {
+		return ComponentStatus{OK: true, Message: "idle"}
+	}
+
+	audioCaptureMutex.Lock()
+	running := audioCapture != nil
+	audioCaptureMutex.Unlock()
+
+	if !running {
+		return ComponentStatus{OK: false, Message: "capture process not running"}
+	}
+	if since(lastAudioFrame.Load()) > readinessWindow {
+		return ComponentStatus{OK: false, Message: "no audio frames received recently"}
+	}
+	return ComponentStatus{OK: true, Message: "streaming"}
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports 200 only when the hub and, if anyone is listening, audio capture are working
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	components := map[string]ComponentStatus{
+		"hub":   hubStatus(),
+		"audio": audioStatus(),
+	}
+
+	ready := true
+	for _, component := range components {
+		ready = ready && component.OK
+	}
+
+	status := "ready"
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		status = "not ready"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     status,
+		"components": components,
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const defaultSTUNServer = "stun:stun.l.google.com:19302"
+
+// ICEServer is the JSON shape of a configured TURN server
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// ICEServerConfig holds the STUN and TURN servers used for new peer connections
+type ICEServerConfig struct {
+	stun  []string
+	turn  []ICEServer
+	mutex sync.RWMutex
+}
+
+var iceServerConfig = &ICEServerConfig{stun: []string{defaultSTUNServer}}
+
+func validateSTUNServer(url string) error {
+	if !strings.HasPrefix(url, "stun:") && !strings.HasPrefix(url, "stuns:") {
+		return fmt.Errorf("STUN URL %q must start with stun: or stuns:", url)
+	}
+	return nil
+}
+
+// setSTUN replaces the STUN servers, an empty list restores the default
+func (c *ICEServerConfig) setSTUN(urls []string) error {
+	for _, url := range urls {
+		if err := validateSTUNServer(url); err != nil {
+			return err
+		}
+	}
+	if len(urls) == 0 {
+		urls = []string{defaultSTUNServer}
+	}
+
+	c.mutex.Lock()
+	c.stun = append([]string(nil), urls...)
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *ICEServerConfig) getSTUN() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]string(nil), c.stun...)
+}
+
+func validateTURNServer(server ICEServer) error {
+	if len(server.URLs) == 0 {
+		return fmt.Errorf("TURN server needs at least one URL")
+	}
+	for _, url := range server.URLs {
+		if !strings.HasPrefix(url, "turn:") && !strings.HasPrefix(url, "turns:") {
+			return fmt.Errorf("TURN URL %q must start with turn: or turns:", url)
+		}
+	}
+	if server.Username == "" || server.Credential == "" {
+		return fmt.Errorf("TURN server %s needs a username and credential", server.URLs[0])
+	}
+	return nil
+}
+
+func (c *ICEServerConfig) setTURN(servers []ICEServer) error {
+	for _, server := range servers {
+		if err := validateTURNServer(server); err != nil {
+			return err
+		}
+	}
+
+	c.mutex.Lock()
+	c.turn = servers
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *ICEServerConfig) getTURN() []ICEServer {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]ICEServer(nil), c.turn...)
+}
+
+// webrtcServers builds the ICE server list for a new peer connection
+func (c *ICEServerConfig) webrtcServers() []webrtc.ICEServer {
+	// Unreachable servers only cost a gathering timeout, host and TURN candidates still work
+	servers := []webrtc.ICEServer{
+		{
+			URLs: c.getSTUN(),
+		},
+	}
+
+	for _, server := range c.getTURN() {
+		servers = append(servers, webrtc.ICEServer{
+			URLs:           server.URLs,
+			Username:       server.Username,
+			Credential:     server.Credential,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	}
+	return servers
+}
+
+// splitURLs parses a comma-separated URL list from the environment
+func splitURLs(value string) []string {
+	var urls []string
+	for _, url := range strings.Split(value, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// loadICEServersFromEnv reads STUN_URLS, TURN_URLS (both comma-separated), TURN_USERNAME and TURN_CREDENTIAL
+func loadICEServersFromEnv() {
+	if stun := splitURLs(os.Getenv("STUN_URLS")); len(stun) > 0 {
+		if err := iceServerConfig.setSTUN(stun); err != nil {
+			log.Printf("Ignoring STUN configuration from environment: %v", err)
+		} else {
+			log.Printf("Configured STUN server %s", strings.Join(stun, ", "))
+		}
+	}
+
+	urls := os.Getenv("TURN_URLS")
+	if urls == "" {
+		return
+	}
+
+	server := ICEServer{
+		URLs:       splitURLs(urls),
+		Username:   os.Getenv("TURN_USERNAME"),
+		Credential: os.Getenv("TURN_CREDENTIAL"),
+	}
+
+	if err := iceServerConfig.setTURN([]ICEServer{server}); err != nil {
+		log.Printf("Ignoring TURN configuration from environment: %v", err)
+		return
+	}
+	log.Printf("Configured TURN server %s", strings.Join(server.URLs, ", "))
+}
+
+func handleICEServers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// Omitted lists are left unchanged
+		var req struct {
+			STUN    *[]string    `json:"stun"`
+			Servers *[]ICEServer `json:"servers"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		// Validate both before applying either so a bad request changes nothing
+		if req.STUN != nil {
+			for _, url := range *req.STUN {
+				if err := validateSTUNServer(url); err != nil {
+					writeJSONError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+		}
+		if req.Servers != nil {
+			if err := iceServerConfig.setTURN(*req.Servers); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			log.Printf("Configured %d TURN servers via HTTP", len(*req.Servers))
+		}
+		if req.STUN != nil {
+			iceServerConfig.setSTUN(*req.STUN)
+			log.Printf("Configured %d STUN servers via HTTP", len(*req.STUN))
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Never echo credentials back
+	servers := iceServerConfig.getTURN()
+	for i := range servers {
+		servers[i].Credential = ""
+	}
+
+	response := map[string]interface{}{
+		"stun":    iceServerConfig.getSTUN(),
+		"servers": servers,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
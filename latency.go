@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// PingMessage is an application-level ping, separate from the WebSocket control frames so
+// the frontend can time it
+type PingMessage struct {
+	Type      string  `json:"type"`
+	Timestamp float64 `json:"timestamp"` // Client clock, echoed back untouched
+}
+
+// PongMessage answers a ping with the client's timestamp and the server time in Unix milliseconds
+type PongMessage struct {
+	Type       string  `json:"type"`
+	Timestamp  float64 `json:"timestamp"`
+	ServerTime int64   `json:"serverTime"`
+}
+
+// handlePingMessage answers on the priority queue so queued broadcasts don't inflate the round trip
+func handlePingMessage(client *Client, msg *PingMessage) {
+	data, err := json.Marshal(PongMessage{
+		Type:       "pong",
+		Timestamp:  msg.Timestamp,
+		ServerTime: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Println("Error marshaling pong message:", err)
+		return
+	}
+	sendPriority(client, data)
+}
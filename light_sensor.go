@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLightCurve maps lux to brightness: dim in the dark, full brightness in daylight
+const defaultLightCurve = "0:5,10:20,100:50,1000:100"
+
+// lightHysteresis is the smallest brightness change the sensor applies, so readings hovering
+// around a step don't make the panel flicker
+const lightHysteresis = 3
+
+// CurvePoint maps a lux reading to a brightness, points are interpolated linearly
+type CurvePoint struct {
+	Lux        float64 `json:"lux"`
+	Brightness int     `json:"brightness"`
+}
+
+// LightSensor polls an ambient light sensor and drives auto-brightness
+type LightSensor struct {
+	path     string // sysfs file holding the lux value, e.g. an IIO in_illuminance_input
+	command  string // Alternatively a shell command printing the lux value
+	interval time.Duration
+	curve    []CurvePoint // Sorted by lux
+	lux      float64
+	readAt   time.Time
+	lastErr  string
+	mutex    sync.RWMutex
+}
+
+var lightSensor = newLightSensor()
+
+// parseLightCurve reads "lux:brightness" pairs separated by commas
+func parseLightCurve(value string) ([]CurvePoint, error) {
+	var curve []CurvePoint
+	for _, pair := range strings.Split(value, ",") {
+		luxValue, brightnessValue, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("curve point %q must be lux:brightness", pair)
+		}
+		lux, err := strconv.ParseFloat(luxValue, 64)
+		if err != nil || lux < 0 {
+			return nil, fmt.Errorf("invalid lux %q", luxValue)
+		}
+		brightness, err := strconv.Atoi(brightnessValue)
+		if err != nil || brightness < 0 || brightness > 100 {
+			return nil, fmt.Errorf("brightness %q must be between 0 and 100", brightnessValue)
+		}
+		curve = append(curve, CurvePoint{Lux: lux, Brightness: brightness})
+	}
+
+	sort.Slice(curve, func(i, j int) bool { return curve[i].Lux < curve[j].Lux })
+	return curve, nil
+}
+
+// newLightSensor returns nil unless LIGHT_SENSOR_PATH or LIGHT_SENSOR_COMMAND is set
+func newLightSensor() *LightSensor {
+	sensor := &LightSensor{
+		path:     os.Getenv("LIGHT_SENSOR_PATH"),
+		command:  os.Getenv("LIGHT_SENSOR_COMMAND"),
+		interval: time.Duration(envInt("LIGHT_SENSOR_INTERVAL", 10)) * time.Second,
+	}
+	if sensor.path == "" && sensor.command == "" {
+		return nil
+	}
+
+	curveValue := os.Getenv("LIGHT_CURVE")
+	if curveValue == "" {
+		curveValue = defaultLightCurve
+	}
+	curve, err := parseLightCurve(curveValue)
+	if err != nil {
+		log.Printf("Invalid LIGHT_CURVE, using default: %v", err)
+		curve, _ = parseLightCurve(defaultLightCurve)
+	}
+	sensor.curve = curve
+	return sensor
+}
+
+// read returns the current lux from the sysfs file or command
+func (ls *LightSensor) read() (float64, error) {
+	var output []byte
+	var err error
+	if ls.path != "" {
+		output, err = os.ReadFile(ls.path)
+	} else {
+		output, err = exec.Command("sh", "-c", ls.command).Output()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	lux, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("sensor output %q is not a number", strings.TrimSpace(string(output)))
+	}
+	return lux, nil
+}
+
+// brightnessFor interpolates the curve, clamping outside its range
+func (ls *LightSensor) brightnessFor(lux float64) int {
+	curve := ls.curve
+	if lux <= curve[0].Lux {
+		return curve[0].Brightness
+	}
+	for i := 1; i < len(curve); i++ {
+		if lux <= curve[i].Lux {
+			low, high := curve[i-1], curve[i]
+			ratio := (lux - low.Lux) / (high.Lux - low.Lux)
+			return low.Brightness + int(ratio*float64(high.Brightness-low.Brightness))
+		}
+	}
+	return curve[len(curve)-1].Brightness
+}
+
+// runLightSensor reads the sensor on an interval and fades to the mapped brightness
+func runLightSensor(hub *Hub) {
+	if lightSensor == nil {
+		return
+	}
+
+	ticker := time.NewTicker(lightSensor.interval)
+	defer ticker.Stop()
+
+	for {
+		lux, err := lightSensor.read()
+
+		lightSensor.mutex.Lock()
+		if err != nil {
+			if lightSensor.lastErr != err.Error() {
+				log.Printf("Failed to read light sensor: %v", err)
+			}
+			lightSensor.lastErr = err.Error()
+		} else {
+			lightSensor.lux = lux
+			lightSensor.readAt = time.Now()
+			lightSensor.lastErr = ""
+		}
+		lightSensor.mutex.Unlock()
+
+		if err == nil && !brightnessSchedule.manualOverrideActive() {
+			target := lightSensor.brightnessFor(lux)
+
+			brightnessState.mutex.RLock()
+			current := brightnessState.value
+			brightnessState.mutex.RUnlock()
+
+			if target-current >= lightHysteresis || current-target >= lightHysteresis {
+				log.Printf("Ambient light %.1f lux, brightness %d", lux, target)
+				brightnessHistory.record(BrightnessChange{Brightness: target, Source: brightnessSourceSensor, Fade: 1000})
+				brightnessFader.start(hub, target, time.Second, updateSourceServer)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+func handleLightSensor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if lightSensor == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Set LIGHT_SENSOR_PATH or LIGHT_SENSOR_COMMAND to enable the light sensor")
+		return
+	}
+
+	lightSensor.mutex.RLock()
+	response := map[string]interface{}{
+		"lux":        lightSensor.lux,
+		"readAt":     lightSensor.readAt,
+		"brightness": lightSensor.brightnessFor(lightSensor.lux),
+		"curve":      lightSensor.curve,
+	}
+	if lightSensor.lastErr != "" {
+		response["error"] = lightSensor.lastErr
+	}
+	lightSensor.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
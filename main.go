@@ -2,38 +2,86 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v3"
-	"github.com/pion/webrtc/v3/pkg/media"
-	opus "gopkg.in/hraban/opus.v2"
 )
 
+const (
+	// Time allowed to write a control frame
+	controlWriteWait = 10 * time.Second
+	// Interval between heartbeat pings
+	pingPeriod = 30 * time.Second
+	// A client that hasn't answered a ping within this window is considered gone
+	pongWait = 2 * pingPeriod
+	// How long a broadcast waits on a full send buffer before counting the message as dropped
+	broadcastSendTimeout = 50 * time.Millisecond
+	// A client that keeps dropping broadcasts for this long is disconnected
+	slowClientTimeout = 5 * time.Second
+)
+
+// wsWriteTimeout bounds each message write so a stalled connection can't block its writePump
+// forever (WS_WRITE_TIMEOUT, in seconds)
+var wsWriteTimeout = time.Duration(envInt("WS_WRITE_TIMEOUT", 10)) * time.Second
+
+// wsMaxMessageSize caps incoming WebSocket messages (WS_MAX_MESSAGE_SIZE, in bytes). SDP offers are
+// the largest messages clients send, a few KB, everything else is tiny JSON.
+var wsMaxMessageSize = int64(envInt("WS_MAX_MESSAGE_SIZE", 32*1024))
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	ReadBufferSize:  envInt("WS_READ_BUFFER_SIZE", 4096),
+	WriteBufferSize: envInt("WS_WRITE_BUFFER_SIZE", 4096),
+	CheckOrigin:     checkOrigin,
 }
 
 type Client struct {
+	id                  string    // Stable identifier assigned on connect
+	resumeToken         string    // Presented on reconnect to restore this client's state
+	displayID           string    // Stable ID the display reports with ?display=, "" when it sends none
+	connectedAt         time.Time // When the WebSocket connection was accepted
+	tab                 string    // Tab this client last reported showing
+	protocol            int       // WebSocket protocol version negotiated on connect
 	conn                *websocket.Conn
+	releaseSlot         func() // Frees the connection's MAX_WS_CONNECTIONS slot
 	send                chan []byte
+	priority            chan []byte // Control and signaling messages, written before anything queued on send
 	peerConnection      *webrtc.PeerConnection
+	releasePeerSlot     func() // Frees the peer connection's MAX_PEER_CONNECTIONS slot, nil without one
 	audioTrack          *webrtc.TrackLocalStaticSample
-	stopAudio           chan struct{} // Signal to stop audio streaming
+	bitrate             *BitrateAdapter        // Opus bitrate the client's link sustains, from RTCP feedback
+	stopAudio           chan struct{}          // Signal to stop audio streaming
+	peerAudioStop       chan struct{}          // Closed when the streaming peer connection fails, nil while not streaming
+	peerAudioConn       *webrtc.PeerConnection // The connection peerAudioStop belongs to
 	webrtcConnected     bool
 	lastRefresh         time.Time
-	refreshCooldown     time.Duration
+	autoRefreshes       int // Consecutive refreshes after WebRTC disconnects, see handleAutoRefresh
+	lastAutoRefresh     time.Time
+	refreshCooldown     *time.Duration // Per-client override of the global refresh cooldown, nil = default
 	webrtcCheckInterval *time.Ticker
+	location            *time.Location // Timezone used for this client's clock, nil = server default
+	muted               bool           // Stream silence instead of audio, keeping the track alive
+	audioStreaming      bool           // An encoder goroutine is running for this client
+	droppedMessages     atomic.Uint64  // Messages skipped because the send buffer was full
+	sendFailingSince    time.Time      // First broadcast drop of the current streak, only used by the hub loop
+	tooSlow             bool           // Set by the hub loop before it closes send to drop the client
+	sendClosed          bool           // Set under mutex when the hub closes send, checked before every send from outside the hub
+	mutex               sync.RWMutex
 }
 
 type Hub struct {
@@ -42,10 +90,15 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	mutex      sync.RWMutex
+
+	lastHeartbeat atomic.Int64 // UnixNano of the last run loop iteration, for readiness
 }
 
 var globalHub *Hub
 
+// lastClientID is incremented for every new WebSocket connection
+var lastClientID atomic.Uint64
+
 func newHub() *Hub {
 	return &Hub{
 		broadcast:  make(chan []byte, 256),
@@ -55,43 +108,160 @@ func newHub() *Hub {
 	}
 }
 
+// findClient returns the connected client with the given ID, or nil
+func (h *Hub) findClient(id string) *Client {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for client := range h.clients {
+		if client.id == id {
+			return client
+		}
+	}
+	return nil
+}
+
+// tryBroadcast queues a message for every client without blocking, so no sender stalls when the hub
+// falls behind. A full queue drops the message and counts it under kind.
+func (h *Hub) tryBroadcast(data []byte, kind string) bool {
+	select {
+	case h.broadcast <- data:
+		return true
+	default:
+		broadcastsDropped.WithLabelValues(kind).Inc()
+		log.Printf("Broadcast queue full, dropping %s update", kind)
+		return false
+	}
+}
+
+// sendPriority queues a control or signaling message ahead of regular traffic.
+// The priority channel is never closed, so this never blocks on a client that went away.
+func sendPriority(client *Client, data []byte) bool {
+	select {
+	case client.priority <- data:
+		return true
+	default:
+		client.droppedMessages.Add(1)
+		websocketMessagesDropped.Add(1)
+		log.Printf("Failed to send priority message to client %s (channel full)", client.id)
+		return false
+	}
+}
+
+// sendToClient queues a message for a single client without blocking
+func sendToClient(client *Client, data []byte) bool {
+	// A client found just before it unregistered may have its channel closed by now
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	if client.sendClosed {
+		return false
+	}
+
+	select {
+	case client.send <- data:
+		return true
+	default:
+		client.droppedMessages.Add(1)
+		websocketMessagesDropped.Add(1)
+		log.Printf("Failed to send message to client %s (channel full)", client.id)
+		return false
+	}
+}
+
 func (h *Hub) run() {
+	heartbeat := time.NewTicker(hubHeartbeatInterval)
+	defer heartbeat.Stop()
+	h.lastHeartbeat.Store(time.Now().UnixNano())
+
 	for {
 		select {
+		case <-heartbeat.C:
+			h.lastHeartbeat.Store(time.Now().UnixNano())
+
 		case client := <-h.register:
 			h.mutex.Lock()
 			h.clients[client] = true
 			h.mutex.Unlock()
-			log.Println("Client registered")
+			log.Printf("Client %s registered", client.id)
 
 		case client := <-h.unregister:
 			h.mutex.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.send)
+				closeSend(client)
 			}
 			h.mutex.Unlock()
-			log.Println("Client unregistered")
+			log.Printf("Client %s unregistered", client.id)
 
 		case message := <-h.broadcast:
+			var slow []*Client
 			h.mutex.RLock()
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if !h.deliver(client, message) {
+					slow = append(slow, client)
 				}
 			}
 			h.mutex.RUnlock()
+
+			if len(slow) > 0 {
+				h.mutex.Lock()
+				for _, client := range slow {
+					if _, ok := h.clients[client]; ok {
+						log.Printf("Client %s dropped messages for %s, disconnecting", client.id, slowClientTimeout)
+						delete(h.clients, client)
+						client.tooSlow = true
+						closeSend(client)
+					}
+				}
+				h.mutex.Unlock()
+			}
 		}
 	}
 }
 
+// closeSend closes the client's send channel, marking it closed so sends racing with the
+// unregister give up instead of panicking
+func closeSend(client *Client) {
+	client.mutex.Lock()
+	client.sendClosed = true
+	close(client.send)
+	client.mutex.Unlock()
+}
+
+// deliver queues a broadcast for one client, giving a full buffer a moment to drain.
+// It returns false once the client has been failing for longer than slowClientTimeout.
+func (h *Hub) deliver(client *Client, message []byte) bool {
+	select {
+	case client.send <- message:
+		client.sendFailingSince = time.Time{}
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(broadcastSendTimeout)
+	defer timer.Stop()
+
+	select {
+	case client.send <- message:
+		client.sendFailingSince = time.Time{}
+		return true
+	case <-timer.C:
+	}
+
+	client.droppedMessages.Add(1)
+	websocketMessagesDropped.Add(1)
+	if client.sendFailingSince.IsZero() {
+		client.sendFailingSince = time.Now()
+	}
+	return time.Since(client.sendFailingSince) < slowClientTimeout
+}
+
 type ClockData struct {
 	Time      string `json:"time"`
 	Date      string `json:"date"`
 	Timestamp int64  `json:"timestamp"`
+	Period    string `json:"period,omitempty"` // AM/PM marker, only set in 12h format
+	Millis    int    `json:"millis"`           // Milliseconds into the current second, for sub-second animations
 }
 
 type WebRTCMessage struct {
@@ -104,15 +274,59 @@ type WebRTCMessage struct {
 type BrightnessMessage struct {
 	Type       string `json:"type"`
 	Brightness int    `json:"brightness"`
+	Duration   int    `json:"duration,omitempty"` // Fade time in milliseconds, 0 = instant
+	Delta      int    `json:"delta,omitempty"`    // Signed step for adjust-brightness
+	ClientID   string `json:"clientId,omitempty"` // Target a single client instead of all
+	Zone       string `json:"zone,omitempty"`     // Target the members of a zone instead of all
+	Source     string `json:"source,omitempty"`   // Who made the change, in brightness-update
 }
 
 type TabMessage struct {
-	Type string `json:"type"`
-	Tab  string `json:"tab"`
+	Type     string `json:"type"`
+	Tab      string `json:"tab"`
+	ClientID string `json:"clientId,omitempty"` // Target a single client instead of all
+	Zone     string `json:"zone,omitempty"`     // Target the members of a zone instead of all
+	Source   string `json:"source,omitempty"`   // Who made the change, in tab-update
 }
 
+// Sources of a tab-update or brightness-update that no client asked for. Changes a WebSocket
+// client made carry its ID instead, so it can ignore the echo of its own change.
+const (
+	updateSourceServer   = "server"   // HTTP API, schedules, sensors and current-state replies
+	updateSourceRotation = "rotation" // Tab rotation
+)
+
 type RefreshMessage struct {
-	Type string `json:"type"`
+	Type     string `json:"type"`
+	ClientID string `json:"clientId,omitempty"` // Refresh another client instead of the sender
+	Zone     string `json:"zone,omitempty"`     // Refresh the members of a zone instead of the sender
+	Force    bool   `json:"force,omitempty"`    // Skip the refresh cooldown
+}
+
+type TimezoneMessage struct {
+	Type     string `json:"type"`
+	Timezone string `json:"timezone"`
+}
+
+type VolumeMessage struct {
+	Type   string `json:"type"`
+	Volume int    `json:"volume"`
+}
+
+type MuteMessage struct {
+	Type     string `json:"type"`
+	Muted    bool   `json:"muted"`
+	ClientID string `json:"clientId,omitempty"` // The client whose mute changed, in mute-update
+}
+
+type TimeFormatMessage struct {
+	Type   string `json:"type"`
+	Format string `json:"format"`
+}
+
+type ErrorMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
 }
 
 type BrightnessState struct {
@@ -125,6 +339,17 @@ type TabState struct {
 	mutex sync.RWMutex
 }
 
+type VolumeState struct {
+	value int
+	gain  float64 // Linear multiplier applied to PCM samples, derived from value
+	mutex sync.RWMutex
+}
+
+type ClockFormatState struct {
+	value string
+	mutex sync.RWMutex
+}
+
 var brightnessState = &BrightnessState{
 	value: 50, // Default brightness (0-100)
 }
@@ -133,31 +358,51 @@ var tabState = &TabState{
 	value: "clock", // Default tab: clock, audio, settings, info
 }
 
+var volumeState = &VolumeState{
+	value: 100, // Default volume (0-100), full amplitude
+	gain:  1,
+}
+
+var clockFormatState = &ClockFormatState{
+	value: "24h", // Default time format: 12h, 24h
+}
+
+// captureIdleTimeout is how long the capture process keeps running after the last listener leaves
+const captureIdleTimeout = 10 * time.Second
+
 // AudioMultiplexer manages audio distribution to multiple clients
 type AudioMultiplexer struct {
-	listeners      map[chan []byte]bool
+	listeners      map[chan []byte]*audioListener
 	listenersMutex sync.RWMutex
 	sourceChannel  chan []byte
+	idleTimer      *time.Timer // Pending capture shutdown, guarded by listenersMutex
 }
 
 func newAudioMultiplexer() *AudioMultiplexer {
 	return &AudioMultiplexer{
-		listeners:     make(map[chan []byte]bool),
-		sourceChannel: make(chan []byte, 100),
+		listeners:     make(map[chan []byte]*audioListener),
+		sourceChannel: make(chan []byte, audioSourceBuffer),
 	}
 }
 
 func (am *AudioMultiplexer) start() {
 	go func() {
 		log.Println("Audio multiplexer started")
+		var gate silenceGate
 		for frame := range am.sourceChannel {
+			gated := gate.filter(frame, audioConfig.get())
+
 			am.listenersMutex.RLock()
-			for ch := range am.listeners {
-				select {
-				case ch <- frame:
-					// Successfully sent
-				default:
-					// Channel full, skip this listener for this frame
+			for ch, listener := range am.listeners {
+				frames := gated
+				if continuousAudioListeners[listener.name] {
+					frames = [][]byte{frame}
+				}
+				for _, frame := range frames {
+					if offerFrame(ch, frame) {
+						listener.dropped.Add(1)
+						listenerFramesDropped.Add(1)
+					}
 				}
 			}
 			am.listenersMutex.RUnlock()
@@ -165,12 +410,20 @@ func (am *AudioMultiplexer) start() {
 	}()
 }
 
-func (am *AudioMultiplexer) subscribe() chan []byte {
-	ch := make(chan []byte, 50)
+// subscribe adds a listener, name identifies the kind of consumer in the buffer stats
+func (am *AudioMultiplexer) subscribe(name string) chan []byte {
+	ch := make(chan []byte, audioListenerBuffer)
 	am.listenersMutex.Lock()
-	am.listeners[ch] = true
+	am.listeners[ch] = &audioListener{id: lastListenerID.Add(1), name: name, subscribedAt: time.Now()}
+	count := len(am.listeners)
+	// Someone is listening again, keep the capture process alive
+	if am.idleTimer != nil {
+		am.idleTimer.Stop()
+		am.idleTimer = nil
+	}
 	am.listenersMutex.Unlock()
-	log.Printf("Client subscribed to audio multiplexer (%d active)", len(am.listeners))
+	log.Printf("Client subscribed to audio multiplexer (%d active)", count)
+	notifyAudioListeners()
 	return ch
 }
 
@@ -178,25 +431,66 @@ func (am *AudioMultiplexer) unsubscribe(ch chan []byte) {
 	am.listenersMutex.Lock()
 	delete(am.listeners, ch)
 	close(ch)
+	count := len(am.listeners)
+	// Stop capturing after a grace period so brief reconnects don't restart parec
+	if count == 0 && am.idleTimer == nil {
+		am.idleTimer = time.AfterFunc(captureIdleTimeout, am.stopIfIdle)
+	}
+	am.listenersMutex.Unlock()
+	log.Printf("Client unsubscribed from audio multiplexer (%d active)", count)
+	notifyAudioListeners()
+}
+
+func (am *AudioMultiplexer) listenerCount() int {
+	am.listenersMutex.RLock()
+	defer am.listenersMutex.RUnlock()
+	return len(am.listeners)
+}
+
+func (am *AudioMultiplexer) stopIfIdle() {
+	am.listenersMutex.Lock()
+	am.idleTimer = nil
+	idle := len(am.listeners) == 0
 	am.listenersMutex.Unlock()
-	log.Printf("Client unsubscribed from audio multiplexer (%d active)", len(am.listeners))
+
+	if idle {
+		log.Printf("No audio listeners for %s, stopping capture", captureIdleTimeout)
+		stopAudioCapture()
+	}
 }
 
 func (am *AudioMultiplexer) broadcast(frame []byte) {
-	select {
-	case am.sourceChannel <- frame:
-		// Successfully queued
-	default:
-		// Source channel full, drop frame
+	if offerFrame(am.sourceChannel, frame) {
+		sourceFramesDropped.Add(1)
 	}
 }
 
+// Backoff bounds for restarting a capture process that died unexpectedly
+const (
+	minCaptureRestartDelay = 1 * time.Second
+	maxCaptureRestartDelay = 30 * time.Second
+)
+
+// captureStartAttempts is how many times ensureAudioCapture tries to open the source before giving
+// up (AUDIO_START_ATTEMPTS), waiting captureStartRetryDelay, doubled each time, in between
+var captureStartAttempts = max(envInt("AUDIO_START_ATTEMPTS", 5), 1)
+
+const captureStartRetryDelay = 500 * time.Millisecond
+
 var (
-	audioCmd         *exec.Cmd
-	audioCmdMutex    sync.Mutex
-	audioMultiplexer *AudioMultiplexer
+	audioCapture        io.ReadCloser // Stream opened from audioSource, nil while stopped
+	audioCaptureStarted time.Time
+	audioCaptureMutex   sync.Mutex
+	captureRestartDelay = minCaptureRestartDelay // Guarded by audioCaptureMutex
+	audioMultiplexer    *AudioMultiplexer
 )
 
+type AudioStatusMessage struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"` // running, reconnecting
+	Message string `json:"message,omitempty"`
+}
+
 func init() {
 	// Initialize audio multiplexer
 	audioMultiplexer = newAudioMultiplexer()
@@ -204,29 +498,80 @@ func init() {
 }
 
 func handleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	releaseSlot := acquireWebSocketSlot()
+	if releaseSlot == nil {
+		rejectWebSocketLimit(w, r)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
+		releaseSlot()
+		return
+	}
+
+	if apiToken != "" && !tokenValid(r) {
+		rejectWebSocket(conn, closeUnauthorized, "Unauthorized")
+		releaseSlot()
+		return
+	}
+
+	protocol, err := negotiateProtocol(r)
+	if err != nil {
+		rejectProtocol(conn, err)
+		releaseSlot()
 		return
 	}
 
 	client := &Client{
+		id:              strconv.FormatUint(lastClientID.Add(1), 10),
+		connectedAt:     time.Now(),
+		tab:             currentTab(),
+		protocol:        protocol,
 		conn:            conn,
 		send:            make(chan []byte, 256),
+		priority:        make(chan []byte, 64),
 		stopAudio:       make(chan struct{}),
 		webrtcConnected: false,
 		lastRefresh:     time.Time{},
-		refreshCooldown: 2 * time.Minute,
+		resumeToken:     newResumeToken(),
+		displayID:       displayIDFromRequest(r),
+		releaseSlot:     releaseSlot,
+	}
+
+	// A reconnecting display picks up its previous ID and settings
+	resumed := false
+	if token := r.URL.Query().Get("resume"); token != "" {
+		if state := resumeStore.take(token); state != nil {
+			state.restore(client)
+			resumed = true
+			log.Printf("Client %s resumed its session", client.id)
+		}
 	}
+	sendSession(client, resumed)
+	sendVersion(client)
 	hub.register <- client
+	sendStateSnapshot(client)
+	webhookNotifier.notify(webhookClientConnected, map[string]interface{}{"clientId": client.id, "resumed": resumed})
 
-	go writePump(client)
+	go writePump(client, conn)
 	go readPump(hub, client)
 }
 
 func readPump(hub *Hub, client *Client) {
+	// Set when the server ends the connection on purpose, sent to the client in the close frame
+	closeCode, closeReason := 0, ""
+
 	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic handling message from client %s: %v", client.id, r)
+			closeCode, closeReason = websocket.CloseInternalServerErr, "Internal error"
+		}
+
 		hub.unregister <- client
+		resumeStore.save(client)
+		webhookNotifier.notify(webhookClientDisconnected, map[string]interface{}{"clientId": client.id})
 		
 		// Stop audio streaming goroutine
 		close(client.stopAudio)
@@ -235,19 +580,55 @@ func readPump(hub *Hub, client *Client) {
 		if client.peerConnection != nil {
 			client.peerConnection.Close()
 		}
+		if client.releasePeerSlot != nil {
+			client.releasePeerSlot()
+		}
 		
+		if closeCode != 0 {
+			closeWebSocket(client.conn, closeCode, closeReason)
+		}
 		client.conn.Close()
+		client.releaseSlot()
 	}()
 
+	// Oversized messages fail the read, the library answers them with a 1009 close frame
+	client.conn.SetReadLimit(wsMaxMessageSize)
+
+	// Each pong pushes the deadline back, a silent client times out and gets unregistered
+	client.conn.SetReadDeadline(time.Now().Add(pongWait))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	limiter := newRateLimiter(wsRateLimit)
+
 	for {
 		_, message, err := client.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("Client %s missed heartbeat, disconnecting", client.id)
+			} else if errors.Is(err, websocket.ErrReadLimit) {
+				log.Printf("Client %s sent a message over %d bytes, disconnecting", client.id, wsMaxMessageSize)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
 
+		ok, throttled, abusive := limiter.allow(time.Now())
+		if abusive {
+			log.Printf("Client %s exceeded the message rate limit, disconnecting", client.id)
+			closeCode, closeReason = websocket.ClosePolicyViolation, "Message rate limit exceeded"
+			break
+		}
+		if !ok {
+			if throttled {
+				log.Printf("Client %s is sending more than %d messages/s, throttling", client.id, wsRateLimit)
+			}
+			continue
+		}
+
 		// Parse message to determine type
 		var typeCheck struct {
 			Type string `json:"type"`
@@ -259,37 +640,148 @@ func readPump(hub *Hub, client *Client) {
 
 		// Route based on message type
 		switch typeCheck.Type {
-		case "set-brightness", "get-brightness":
+		case "set-brightness", "adjust-brightness", "get-brightness":
 			var brightnessMsg BrightnessMessage
 			if err := json.Unmarshal(message, &brightnessMsg); err == nil {
-				handleBrightnessMessage(hub, &brightnessMsg)
+				handleBrightnessMessage(hub, client, &brightnessMsg)
 			} else {
 				log.Printf("Error parsing brightness message: %v", err)
 			}
+		case "get-state":
+			sendStateSnapshot(client)
+		case "set-state":
+			var stateMsg SetStateMessage
+			if err := json.Unmarshal(message, &stateMsg); err == nil {
+				handleSetStateMessage(hub, client, &stateMsg)
+			} else {
+				log.Printf("Error parsing set-state message: %v", err)
+			}
 		case "set-tab", "get-tab":
 			var tabMsg TabMessage
 			if err := json.Unmarshal(message, &tabMsg); err == nil {
-				handleTabMessage(hub, &tabMsg)
+				handleTabMessage(hub, client, &tabMsg)
 			} else {
 				log.Printf("Error parsing tab message: %v", err)
 			}
 		case "refresh":
 			var refreshMsg RefreshMessage
 			if err := json.Unmarshal(message, &refreshMsg); err == nil {
-				handleRefreshMessage(client)
+				if refreshMsg.Zone != "" {
+					if refreshMsg.ClientID != "" {
+						sendError(client, errZoneAndClient.Error())
+						continue
+					}
+					members, err := zoneManager.members(hub, refreshMsg.Zone)
+					if err != nil {
+						sendError(client, err.Error())
+						continue
+					}
+					for _, member := range members {
+						handleRefreshMessage(member, refreshMsg.Force)
+					}
+					continue
+				}
+
+				target := client
+				if refreshMsg.ClientID != "" {
+					if target = hub.findClient(refreshMsg.ClientID); target == nil {
+						sendError(client, fmt.Sprintf("Client %s not connected", refreshMsg.ClientID))
+						continue
+					}
+				}
+				handleRefreshMessage(target, refreshMsg.Force)
 			} else {
 				log.Printf("Error parsing refresh message: %v", err)
 			}
+		case "set-refresh-cooldown":
+			var cooldownMsg RefreshCooldownMessage
+			if err := json.Unmarshal(message, &cooldownMsg); err == nil {
+				handleRefreshCooldownMessage(client, &cooldownMsg)
+			} else {
+				log.Printf("Error parsing refresh cooldown message: %v", err)
+			}
+		case "set-volume", "get-volume":
+			var volumeMsg VolumeMessage
+			if err := json.Unmarshal(message, &volumeMsg); err == nil {
+				handleVolumeMessage(hub, client, &volumeMsg)
+			} else {
+				log.Printf("Error parsing volume message: %v", err)
+			}
+		case "set-balance", "get-balance":
+			var balanceMsg BalanceMessage
+			if err := json.Unmarshal(message, &balanceMsg); err == nil {
+				handleBalanceMessage(hub, client, &balanceMsg)
+			} else {
+				log.Printf("Error parsing balance message: %v", err)
+			}
+		case "set-audio-enabled":
+			var enabledMsg AudioEnabledMessage
+			if err := json.Unmarshal(message, &enabledMsg); err == nil {
+				handleAudioEnabledMessage(hub, client, &enabledMsg)
+			} else {
+				log.Printf("Error parsing audio enabled message: %v", err)
+			}
+		case "set-mute":
+			var muteMsg MuteMessage
+			if err := json.Unmarshal(message, &muteMsg); err == nil {
+				handleMuteMessage(hub, client, &muteMsg)
+			} else {
+				log.Printf("Error parsing mute message: %v", err)
+			}
+		case "set-time-format", "get-time-format":
+			var formatMsg TimeFormatMessage
+			if err := json.Unmarshal(message, &formatMsg); err == nil {
+				handleTimeFormatMessage(hub, client, &formatMsg)
+			} else {
+				log.Printf("Error parsing time format message: %v", err)
+			}
+		case "set-banner":
+			var bannerMsg BannerMessage
+			if err := json.Unmarshal(message, &bannerMsg); err == nil {
+				handleBannerMessage(hub, client, &bannerMsg)
+			} else {
+				log.Printf("Error parsing banner message: %v", err)
+			}
+		case "start-timer", "cancel-timer":
+			var timerMsg TimerMessage
+			if err := json.Unmarshal(message, &timerMsg); err == nil {
+				handleTimerMessage(hub, client, &timerMsg)
+			} else {
+				log.Printf("Error parsing timer message: %v", err)
+			}
+		case "get-sun-times":
+			handleSunTimesMessage(client)
+		case "get-weather":
+			handleWeatherMessage(client)
+		case "set-timezone":
+			var tzMsg TimezoneMessage
+			if err := json.Unmarshal(message, &tzMsg); err == nil {
+				handleTimezoneMessage(client, &tzMsg)
+			} else {
+				log.Printf("Error parsing timezone message: %v", err)
+			}
+		case "ping":
+			var pingMsg PingMessage
+			if err := json.Unmarshal(message, &pingMsg); err == nil {
+				handlePingMessage(client, &pingMsg)
+			} else {
+				log.Printf("Error parsing ping message: %v", err)
+			}
 		case "webrtc-connected":
+			client.mutex.Lock()
 			client.webrtcConnected = true
+			client.mutex.Unlock()
 			log.Println("Client WebRTC connected")
 		case "webrtc-disconnected":
-			if client.webrtcConnected {
+			client.mutex.Lock()
+			wasConnected := client.webrtcConnected
+			client.webrtcConnected = false
+			client.mutex.Unlock()
+			if wasConnected {
 				log.Println("Client WebRTC disconnected, initiating refresh")
-				client.webrtcConnected = false
 				go handleAutoRefresh(client)
 			}
-		case "webrtc-offer", "ice-candidate":
+		case "webrtc-offer", "webrtc-answer", "ice-candidate":
 			var msg WebRTCMessage
 			if err := json.Unmarshal(message, &msg); err == nil {
 				handleWebRTCMessage(client, &msg)
@@ -297,19 +789,81 @@ func readPump(hub *Hub, client *Client) {
 				log.Printf("Error parsing WebRTC message: %v", err)
 			}
 		default:
-			// Broadcast other messages
-			hub.broadcast <- message
+			// Only allowlisted types are relayed, everything else is reported back to the sender
+			if !relayedMessageTypes[typeCheck.Type] {
+				sendError(client, fmt.Sprintf("Unknown message type %q", typeCheck.Type))
+				continue
+			}
+			hub.tryBroadcast(message, typeCheck.Type)
 		}
 	}
 }
 
-func writePump(client *Client) {
-	defer client.conn.Close()
+// wsWriter is the write side of a client's WebSocket connection, which writePump owns
+type wsWriter interface {
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
 
-	for message := range client.send {
-		if err := client.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Println("Write error:", err)
-			return
+// writeMessage writes one text message under the write deadline. Any error ends the writePump,
+// whose deferred Close makes readPump fail and unregister the client.
+func writeMessage(conn wsWriter, client *Client, message []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	err := conn.WriteMessage(websocket.TextMessage, message)
+	if err == nil {
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		log.Printf("Write to client %s timed out after %s, disconnecting", client.id, wsWriteTimeout)
+	} else {
+		log.Println("Write error:", err)
+	}
+	return err
+}
+
+func writePump(client *Client, conn wsWriter) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		// Drain pending control messages before looking at regular traffic
+		select {
+		case message := <-client.priority:
+			if err := writeMessage(conn, client, message); err != nil {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case message := <-client.priority:
+			if err := writeMessage(conn, client, message); err != nil {
+				return
+			}
+		case message, ok := <-client.send:
+			if !ok {
+				// Hub closed the channel, telling the client why if it was dropped
+				if client.tooSlow {
+					closeWebSocket(conn, websocket.CloseTryAgainLater, "Too slow to keep up")
+				}
+				return
+			}
+			if err := writeMessage(conn, client, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(controlWriteWait)); err != nil {
+				log.Println("Ping error:", err)
+				return
+			}
 		}
 	}
 }
@@ -338,18 +892,151 @@ func handleSnapStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(status)
 }
 
-func handleConfig(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	
-	timezone := os.Getenv("TZ")
-	if timezone == "" {
-		timezone = "UTC"
+// defaultLocation returns the server-wide timezone, from the saved config or the TZ env var. It can
+// change at runtime through /api/config, so callers shouldn't hold on to it.
+func defaultLocation() *time.Location {
+	return serverConfig.getLocation()
+}
+
+func newClockData(now time.Time, format string, layout ClockLayout) ClockData {
+	data := ClockData{
+		Time:      layout.format(now, layout.Time, defaultTimeLayout),
+		Date:      layout.format(now, layout.Date, defaultDateLayout),
+		Timestamp: now.Unix(),
+		Millis:    now.Nanosecond() / int(time.Millisecond),
 	}
-	
-	config := map[string]string{
-		"timezone": timezone,
+	if format == "12h" {
+		data.Time = layout.format(now, layout.Time12h, defaultTimeLayout12h)
+		data.Period = now.Format("PM")
+	}
+	return data
+}
+
+// clockTickInterval reads CLOCK_TICK_MS, the default of one tick per second spares low-power clients
+func clockTickInterval() time.Duration {
+	interval := time.Duration(envInt("CLOCK_TICK_MS", 1000)) * time.Millisecond
+	if interval < 50*time.Millisecond {
+		interval = 50 * time.Millisecond
+	}
+	return interval
+}
+
+// clockTabOnly skips time messages for displays showing another tab, sparing battery-powered
+// displays traffic they don't render. CLOCK_TAB_ONLY=false ticks every client.
+var clockTabOnly = os.Getenv("CLOCK_TAB_ONLY") != "false"
+
+// broadcastTime sends every client the current time formatted in its own timezone
+func broadcastTime(hub *Hub) {
+	ticker := time.NewTicker(clockTickInterval())
+	defer ticker.Stop()
+
+	var lastSecond int64
+
+	for now := range ticker.C {
+		clockFormatState.mutex.RLock()
+		format := clockFormatState.value
+		clockFormatState.mutex.RUnlock()
+		serverLocation := defaultLocation()
+		layout := serverConfig.clockLayout()
+
+		// Shared by every client since the zones are fixed, nil when none are configured.
+		// Sent once per second even when the clock ticks faster.
+		var worldClocks []byte
+		if now.Unix() != lastSecond {
+			lastSecond = now.Unix()
+			worldClocks = worldClockState.message(now, format, layout)
+		}
+
+		hub.mutex.RLock()
+		for client := range hub.clients {
+			client.mutex.RLock()
+			loc := client.location
+			tab := client.tab
+			client.mutex.RUnlock()
+
+			// Clients that haven't reported a tab yet still get the time
+			if clockTabOnly && tab != "" && tab != "clock" {
+				continue
+			}
+			if loc == nil {
+				loc = serverLocation
+			}
+
+			data, err := json.Marshal(struct {
+				Type string `json:"type"`
+				ClockData
+			}{
+				Type:      "time",
+				ClockData: newClockData(now.In(loc), format, layout),
+			})
+			if err != nil {
+				log.Println("Error marshaling time message:", err)
+				continue
+			}
+
+			select {
+			case client.send <- data:
+			default:
+				// Client is busy, it will get the next tick
+				client.droppedMessages.Add(1)
+				websocketMessagesDropped.Add(1)
+			}
+
+			if worldClocks != nil {
+				select {
+				case client.send <- worldClocks:
+				default:
+				}
+			}
+		}
+		hub.mutex.RUnlock()
+	}
+}
+
+func handleTimezoneMessage(client *Client, msg *TimezoneMessage) {
+	if msg.Timezone == "" {
+		client.mutex.Lock()
+		client.location = nil
+		client.mutex.Unlock()
+		log.Println("Client timezone reset to server default")
+		return
+	}
+
+	loc, err := time.LoadLocation(msg.Timezone)
+	if err != nil {
+		log.Printf("Rejected invalid timezone %q: %v", msg.Timezone, err)
+		sendError(client, fmt.Sprintf("Invalid timezone %q", msg.Timezone))
+		return
+	}
+
+	client.mutex.Lock()
+	client.location = loc
+	client.mutex.Unlock()
+	log.Printf("Client timezone set to %s", loc.String())
+}
+
+// sendError reports a problem back to a single client
+func sendError(client *Client, message string) {
+	data, err := json.Marshal(ErrorMessage{
+		Type:    "error",
+		Message: message,
+	})
+	if err != nil {
+		log.Println("Error marshaling error message:", err)
+		return
+	}
+
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	if client.sendClosed {
+		return
+	}
+
+	select {
+	case client.send <- data:
+	default:
+		log.Println("Failed to send error message (channel full)")
 	}
-	json.NewEncoder(w).Encode(config)
 }
 
 func handleWebRTCMessage(client *Client, msg *WebRTCMessage) {
@@ -358,65 +1045,114 @@ func handleWebRTCMessage(client *Client, msg *WebRTCMessage) {
 		handleWebRTCOffer(client, msg.Offer)
 	case "ice-candidate":
 		handleICECandidate(client, msg.Candidate)
+	case "webrtc-answer":
+		handleWebRTCAnswer(client, msg.Answer)
 	}
 }
 
 func handleWebRTCOffer(client *Client, offer *webrtc.SessionDescription) {
 	log.Println("Received WebRTC offer")
 
+	// A new offer replaces the client's previous connection and its slot
+	client.mutex.Lock()
+	previous, releasePrevious := client.peerConnection, client.releasePeerSlot
+	client.peerConnection, client.releasePeerSlot = nil, nil
+	client.mutex.Unlock()
+	if previous != nil {
+		stopPeerAudio(client, previous)
+		previous.Close()
+	}
+	if releasePrevious != nil {
+		releasePrevious()
+	}
+
+	release := acquirePeerSlot()
+	if release == nil {
+		log.Printf("Rejected WebRTC offer from client %s, %d peer connections active", client.id, maxPeerConnections)
+		sendWebRTCRejected(client)
+		return
+	}
+
 	// Create WebRTC configuration
 	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
+		ICEServers: iceServerConfig.webrtcServers(),
 	}
 
 	// Create peer connection
 	peerConnection, err := webrtc.NewPeerConnection(config)
 	if err != nil {
 		log.Printf("Failed to create peer connection: %v", err)
+		release()
 		return
 	}
 
+	client.mutex.Lock()
 	client.peerConnection = peerConnection
+	client.releasePeerSlot = release
+	client.mutex.Unlock()
+
+	// A connection that fails to set up is of no use to the client, closing it gives the slot
+	// back instead of holding it until the next offer or disconnect
+	abandon := func() {
+		client.mutex.Lock()
+		if client.peerConnection == peerConnection {
+			client.peerConnection = nil
+			client.releasePeerSlot = nil
+		}
+		client.mutex.Unlock()
+		peerConnection.Close()
+		release()
+	}
 
 	// Set remote description FIRST
 	if err := peerConnection.SetRemoteDescription(*offer); err != nil {
 		log.Printf("Failed to set remote description: %v", err)
+		abandon()
 		return
 	}
 
-	// Create audio track with Opus - best quality and timing for WebRTC
+	// Opus gives the best quality and timing, G.711 covers clients that can't decode it
+	codec := negotiateCodec(offer)
+	log.Printf("Streaming %s audio to client %s", codec.name, client.id)
+
 	audioTrack, err := webrtc.NewTrackLocalStaticSample(
-		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus},
+		webrtc.RTPCodecCapability{MimeType: codec.mimeType},
 		"audio",
 		"smartclock-stream",
 	)
 	if err != nil {
 		log.Printf("Failed to create audio track: %v", err)
+		abandon()
 		return
 	}
 
+	client.mutex.Lock()
 	client.audioTrack = audioTrack
+	client.mutex.Unlock()
 
 	// Add track to peer connection
 	rtpSender, err := peerConnection.AddTrack(audioTrack)
 	if err != nil {
 		log.Printf("Failed to add track: %v", err)
+		abandon()
 		return
 	}
 
 	log.Printf("Added audio track to peer connection")
 
-	// Read RTP packets (required but we don't use them)
+	// Read RTCP feedback (required to keep the interceptors running) and adapt the client's
+	// bitrate to the loss and bandwidth it reports
+	adapter := newBitrateAdapter()
+	client.mutex.Lock()
+	client.bitrate = adapter
+	client.mutex.Unlock()
 	go func() {
-		rtcpBuf := make([]byte, 1500)
 		for {
-			if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
+			packets, _, rtcpErr := rtpSender.ReadRTCP()
+			if rtcpErr != nil {
 				return
 			}
+			adapter.handleRTCP(client.id, packets)
 		}
 	}()
 
@@ -436,7 +1172,7 @@ func handleWebRTCOffer(client *Client, offer *webrtc.SessionDescription) {
 			return
 		}
 
-		client.send <- candidateJSON
+		sendPriority(client, candidateJSON)
 	})
 
 	// Handle connection state changes
@@ -444,9 +1180,17 @@ func handleWebRTCOffer(client *Client, offer *webrtc.SessionDescription) {
 		log.Printf("Peer connection state: %s", state.String())
 		if state == webrtc.PeerConnectionStateConnected {
 			log.Println("WebRTC connection established, starting audio stream")
-			go streamAudioToTrack(client.audioTrack, client.stopAudio)
+			webhookNotifier.notify(webhookWebRTCConnected, map[string]interface{}{"clientId": client.id})
+			startClientAudio(client)
 		} else if state == webrtc.PeerConnectionStateDisconnected || state == webrtc.PeerConnectionStateFailed {
-			log.Println("WebRTC connection lost")
+			// Stop encoding for a dead connection even though the WebSocket stays up. A
+			// disconnect that recovers reaches Connected again and restarts the stream.
+			log.Println("WebRTC connection lost, stopping audio stream")
+			webhookNotifier.notify(webhookWebRTCLost, map[string]interface{}{"clientId": client.id, "state": state.String()})
+			stopPeerAudio(client, peerConnection)
+		} else if state == webrtc.PeerConnectionStateClosed {
+			stopPeerAudio(client, peerConnection)
+			release()
 		}
 	})
 
@@ -454,12 +1198,14 @@ func handleWebRTCOffer(client *Client, offer *webrtc.SessionDescription) {
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
 		log.Printf("Failed to create answer: %v", err)
+		abandon()
 		return
 	}
 
 	// Set local description
 	if err := peerConnection.SetLocalDescription(answer); err != nil {
 		log.Printf("Failed to set local description: %v", err)
+		abandon()
 		return
 	}
 
@@ -470,75 +1216,233 @@ func handleWebRTCOffer(client *Client, offer *webrtc.SessionDescription) {
 	})
 	if err != nil {
 		log.Printf("Failed to marshal answer: %v", err)
+		abandon()
 		return
 	}
 
-	client.send <- answerJSON
-	log.Println("Sent WebRTC answer")
+	if sendPriority(client, answerJSON) {
+		log.Println("Sent WebRTC answer")
+	}
+
+	// Registered after the initial exchange so only later track changes trigger a server offer
+	peerConnection.OnNegotiationNeeded(func() {
+		renegotiate(client, peerConnection)
+	})
 }
 
-func handleICECandidate(client *Client, candidate *webrtc.ICECandidateInit) {
-	if client.peerConnection == nil {
-		log.Println("No peer connection for ICE candidate")
+// renegotiate sends a server-side offer when tracks change on an established connection
+func renegotiate(client *Client, peerConnection *webrtc.PeerConnection) {
+	if peerConnection.SignalingState() != webrtc.SignalingStateStable {
+		log.Println("Negotiation needed while signaling is in progress, skipping")
 		return
 	}
 
-	if err := client.peerConnection.AddICECandidate(*candidate); err != nil {
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		log.Printf("Failed to create renegotiation offer: %v", err)
+		return
+	}
+
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		log.Printf("Failed to set local description: %v", err)
+		return
+	}
+
+	offerJSON, err := json.Marshal(WebRTCMessage{
+		Type:  "webrtc-offer",
+		Offer: &offer,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal offer: %v", err)
+		return
+	}
+
+	if sendPriority(client, offerJSON) {
+		log.Println("Sent WebRTC renegotiation offer")
+	}
+}
+
+// handleWebRTCAnswer completes a server-initiated renegotiation
+func handleWebRTCAnswer(client *Client, answer *webrtc.SessionDescription) {
+	if client.peerConnection == nil || answer == nil {
+		log.Println("No peer connection for WebRTC answer")
+		return
+	}
+
+	if err := client.peerConnection.SetRemoteDescription(*answer); err != nil {
+		log.Printf("Failed to set remote description: %v", err)
+		return
+	}
+	log.Println("WebRTC renegotiation complete")
+}
+
+func handleICECandidate(client *Client, candidate *webrtc.ICECandidateInit) {
+	if client.peerConnection == nil {
+		log.Println("No peer connection for ICE candidate")
+		return
+	}
+
+	if err := client.peerConnection.AddICECandidate(*candidate); err != nil {
 		log.Printf("Failed to add ICE candidate: %v", err)
 	}
 }
 
 func ensureAudioCapture() error {
-	audioCmdMutex.Lock()
-	defer audioCmdMutex.Unlock()
+	audioCaptureMutex.Lock()
+	defer audioCaptureMutex.Unlock()
 	
 	// Check if audio capture is already running
-	if audioCmd != nil && audioCmd.Process != nil {
+	if audioCapture != nil {
 		log.Println("Audio capture process already running")
 		return nil
 	}
 	
-	// Start new audio capture process
-	log.Println("Starting persistent audio capture process...")
-	cmd := exec.Command("parec",
-		"--format=s16le",
-		"--rate=48000",
-		"--channels=2",
-		"--latency-msec=10",
-		"--process-time-msec=10",
-		"--device=snapcast_sink.monitor",
-	)
-	
-	stdout, err := cmd.StdoutPipe()
+	// Start a new capture from the configured source
+	settings := audioConfig.get()
+	log.Printf("Starting persistent audio capture from %s (%s frames)...", audioSource, settings.frameDuration())
+	capture, err := openAudioSource()
 	if err != nil {
 		return err
 	}
 	
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-	
-	audioCmd = cmd
+	audioCapture = capture
+	audioCaptureStarted = time.Now()
 	
 	// Start background goroutine to continuously read and buffer audio
-	go drainAudioPipe(stdout)
+	go func() {
+		drainAudioPipe(capture, settings.pcmFrameSize())
+		handleCaptureExit(capture)
+	}()
 	
 	log.Println("Persistent audio capture started with background drainer")
 	return nil
 }
 
-// drainAudioPipe continuously reads from the audio pipe and broadcasts to all listeners
-func drainAudioPipe(reader io.Reader) {
-	const pcmFrameSize = 3840 // 20ms at 48kHz stereo
+// openAudioSource retries a source that fails to start, such as parec while PulseAudio is still
+// coming up at boot. The capture mutex stays held so concurrent callers wait for the outcome
+// instead of starting their own attempts.
+func openAudioSource() (io.ReadCloser, error) {
+	delay := captureStartRetryDelay
+	for attempt := 1; ; attempt++ {
+		capture, err := audioSource.Open()
+		if err == nil {
+			return capture, nil
+		}
+		// A missing parec won't show up by waiting
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, err
+		}
+		if attempt >= captureStartAttempts {
+			return nil, fmt.Errorf("%v (gave up after %d attempts)", err, attempt)
+		}
+
+		log.Printf("Failed to start audio capture (attempt %d/%d), retrying in %s: %v", attempt, captureStartAttempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// handleCaptureExit runs once a drainer exits. If the capture wasn't stopped on purpose
+// and clients are still listening, it is restarted with exponential backoff.
+func handleCaptureExit(capture io.ReadCloser) {
+	audioCaptureMutex.Lock()
+	if audioCapture != capture {
+		// Stopped deliberately by stopAudioCapture
+		audioCaptureMutex.Unlock()
+		return
+	}
+
+	capture.Close()
+	audioCapture = nil
+
+	// A process that ran for a while resets the backoff, one that keeps dying grows it
+	if time.Since(audioCaptureStarted) > maxCaptureRestartDelay {
+		captureRestartDelay = minCaptureRestartDelay
+	}
+	delay := captureRestartDelay
+	captureRestartDelay = min(captureRestartDelay*2, maxCaptureRestartDelay)
+	audioCaptureMutex.Unlock()
+
+	if audioMultiplexer.listenerCount() == 0 {
+		log.Println("Audio capture process exited with no listeners, not restarting")
+		return
+	}
+
+	log.Println("Audio capture process exited unexpectedly")
+	go superviseAudioCapture(delay)
+}
+
+// superviseAudioCapture restarts the capture process until it succeeds or nobody is listening
+func superviseAudioCapture(delay time.Duration) {
+	for {
+		broadcastAudioStatus("reconnecting", fmt.Sprintf("Audio capture stopped, retrying in %s", delay))
+		log.Printf("Restarting audio capture in %s", delay)
+		time.Sleep(delay)
+
+		if audioMultiplexer.listenerCount() == 0 {
+			log.Println("No audio listeners left, giving up capture restart")
+			return
+		}
+
+		err := ensureAudioCapture()
+		if err == nil {
+			broadcastAudioStatus("running", "")
+			return
+		}
+
+		log.Printf("Failed to restart audio capture: %v", err)
+		delay = min(delay*2, maxCaptureRestartDelay)
+	}
+}
+
+func broadcastAudioStatus(status, message string) {
+	if globalHub == nil {
+		return
+	}
+
+	data, err := json.Marshal(AudioStatusMessage{
+		Type:    "audio-status",
+		Status:  status,
+		Message: message,
+	})
+	if err != nil {
+		log.Println("Error marshaling audio status message:", err)
+		return
+	}
+
+	globalHub.tryBroadcast(data, "audio status")
+}
+
+// stopAudioCapture closes the capture so the next listener starts a fresh one
+func stopAudioCapture() {
+	audioCaptureMutex.Lock()
+	defer audioCaptureMutex.Unlock()
+
+	if audioCapture == nil {
+		return
+	}
+
+	// Closing ends the drainer, which then sees audioCapture changed and doesn't restart
+	if err := audioCapture.Close(); err != nil {
+		log.Printf("Failed to stop audio capture: %v", err)
+	}
+	audioCapture = nil
+	log.Println("Audio capture process stopped")
+}
+
+// drainAudioPipe continuously reads from the audio pipe and broadcasts to all listeners.
+// The frame size is fixed for the life of the capture process.
+func drainAudioPipe(reader io.Reader, pcmFrameSize int) {
 	bufReader := bufio.NewReaderSize(reader, pcmFrameSize*2)
 	
 	log.Println("Background audio drainer started")
 	
+	var meter AudioLevelMeter
 	for {
 		buffer := make([]byte, pcmFrameSize)
 		n, err := io.ReadFull(bufReader, buffer)
 		if err != nil {
-			if err != io.EOF {
+			if err != io.EOF && err != io.ErrUnexpectedEOF && !errors.Is(err, os.ErrClosed) {
 				log.Printf("Audio pipe read error: %v", err)
 			}
 			log.Println("Audio pipe closed, drainer exiting")
@@ -546,155 +1450,112 @@ func drainAudioPipe(reader io.Reader) {
 		}
 		
 		if n == pcmFrameSize {
+			lastAudioFrame.Store(time.Now().UnixNano())
+
 			// Broadcast to all subscribers via multiplexer
 			audioMultiplexer.broadcast(buffer)
+
+			// Metering happens after the frame is queued so it doesn't delay streaming
+			if meter.add(buffer) {
+				broadcastAudioLevel(meter.levels())
+			}
 		}
 	}
 }
 
-func streamAudioToTrack(track *webrtc.TrackLocalStaticSample, stopAudio <-chan struct{}) {
-	// Ensure the shared audio capture process is running
-	if err := ensureAudioCapture(); err != nil {
-		log.Printf("Failed to start audio capture: %v", err)
-		return
-	}
+// streamAudioToTrack feeds the track from the shared encoder until the client disconnects
+// (stopAudio), its peer connection fails (peerStop) or the encoder drops it
+func streamAudioToTrack(client *Client, track *webrtc.TrackLocalStaticSample, stopAudio, peerStop <-chan struct{}) {
+	subscriber := sharedEncoder.add(client, track)
+	defer sharedEncoder.leave(subscriber)
 
 	log.Println("Client connected to audio stream")
-
-	// Subscribe to the audio multiplexer
-	audioChannel := audioMultiplexer.subscribe()
-	defer audioMultiplexer.unsubscribe(audioChannel)
-
 	defer func() {
 		log.Println("Client disconnected from audio stream")
 	}()
-	
-	// Stop if client disconnects
-	done := make(chan struct{})
-	go func() {
-		<-stopAudio
-		log.Println("Stopping audio stream for this client")
-		close(done)
-	}()
 
-	// Create Opus encoder with optimal settings for low latency
-	const sampleRate = 48000
-	const channels = 2
-	const frameDuration = 20 * time.Millisecond
-	
-	enc, err := opus.NewEncoder(sampleRate, channels, opus.AppAudio)
-	if err != nil {
-		log.Printf("Failed to create Opus encoder: %v", err)
-		return
+	select {
+	case <-stopAudio:
+		log.Println("Stopping audio stream for this client")
+	case <-peerStop:
+		log.Println("Stopping audio stream for this client's failed peer connection")
+	case <-subscriber.removed:
 	}
-	
-	// Set low latency and high quality
-	enc.SetBitrate(128000)
-	enc.SetComplexity(5) // Balance between quality and speed
+}
 
-	// PCM frame size: 20ms at 48kHz stereo = 960 samples * 2 channels * 2 bytes = 3840 bytes
-	const pcmFrameSize = 3840
-	pcmBuffer := make([]int16, pcmFrameSize/2) // int16 samples
-	opusBuffer := make([]byte, 4000)           // Opus output buffer
-	
-	log.Printf("Starting Opus encoding (48kHz stereo @ 20ms frames)")
-	
-	sampleCount := 0
-	startTime := time.Now()
-	consecutiveSilentFrames := 0
-	const silenceThreshold = int16(100)    // Amplitude threshold for silence detection
-	const maxSilentFrames = 25             // 25 frames = 500ms of silence before stopping
-	streamingActive := true
-	
-	for {
-		select {
-		case <-done:
-			// Client disconnected, exit this goroutine
-			return
-		case rawBuffer := <-audioChannel:
-			// Convert bytes to int16 samples and check for silence
-			isSilent := true
-			for i := 0; i < len(pcmBuffer); i++ {
-				sample := int16(rawBuffer[i*2]) | int16(rawBuffer[i*2+1])<<8
-				pcmBuffer[i] = sample
-				
-				// Check if sample exceeds silence threshold
-				if sample > silenceThreshold || sample < -silenceThreshold {
-					isSilent = false
-				}
-			}
-			
-			// Track consecutive silent frames
-			if isSilent {
-				consecutiveSilentFrames++
-				if consecutiveSilentFrames == maxSilentFrames && streamingActive {
-					log.Println("Silence detected, pausing stream")
-					streamingActive = false
-				}
-			} else {
-				if consecutiveSilentFrames >= maxSilentFrames && !streamingActive {
-					log.Println("Audio detected, resuming stream")
-					streamingActive = true
-				}
-				consecutiveSilentFrames = 0
-			}
-			
-			// Only encode and send if streaming is active
-			if !streamingActive {
-				continue
+func handleBrightnessMessage(hub *Hub, client *Client, msg *BrightnessMessage) {
+	fmt.Println("Received brightness message:", msg.Type)
+	switch msg.Type {
+	case "set-brightness":
+		if msg.Zone != "" {
+			if msg.ClientID != "" {
+				sendError(client, errZoneAndClient.Error())
+				return
 			}
-			
-			// Encode to Opus
-			opusLen, err := enc.Encode(pcmBuffer, opusBuffer)
+			members, err := zoneManager.members(hub, msg.Zone)
 			if err != nil {
-				log.Printf("Opus encoding error: %v", err)
-				continue
-			}
-			
-			// Send encoded Opus data
-			if err := track.WriteSample(media.Sample{
-				Data:     opusBuffer[:opusLen],
-				Duration: frameDuration,
-			}); err != nil {
-				log.Printf("Failed to write sample: %v", err)
+				sendError(client, err.Error())
 				return
 			}
-			
-			sampleCount++
-			if sampleCount%50 == 0 {
-				elapsed := time.Since(startTime).Seconds()
-				packetsPerSec := float64(sampleCount) / elapsed
-				log.Printf("Streamed %d Opus packets (%.1f pkt/s, %d bytes)", sampleCount, packetsPerSec, opusLen)
+			log.Printf("Brightness set to %d for zone %s (%d connected)", msg.Brightness, msg.Zone, len(members))
+			brightnessHistory.record(BrightnessChange{Brightness: msg.Brightness, Source: brightnessSourceWebSocket, ClientID: client.id, Zone: msg.Zone})
+			for _, member := range members {
+				sendBrightness(member, msg.Brightness, client.id)
 			}
+			return
 		}
-	}
-}
 
-func handleBrightnessMessage(hub *Hub, msg *BrightnessMessage) {
-	fmt.Println("Received brightness message:", msg.Type)
-	switch msg.Type {
-	case "set-brightness":
-		brightnessState.mutex.Lock()
-		brightnessState.value = msg.Brightness
-		brightnessState.mutex.Unlock()
-		log.Printf("Brightness set to %d", msg.Brightness)
-		
-		// Broadcast brightness update to all clients
-		broadcastBrightness(hub, msg.Brightness)
+		if msg.ClientID != "" {
+			target := hub.findClient(msg.ClientID)
+			if target == nil {
+				sendError(client, fmt.Sprintf("Client %s not connected", msg.ClientID))
+				return
+			}
+			log.Printf("Brightness set to %d for client %s", msg.Brightness, target.id)
+			brightnessHistory.record(BrightnessChange{Brightness: msg.Brightness, Source: brightnessSourceWebSocket, ClientID: client.id, Target: target.id})
+			sendBrightness(target, msg.Brightness, client.id)
+			return
+		}
+
+		log.Printf("Brightness set to %d (fade %dms)", msg.Brightness, msg.Duration)
+		brightnessHistory.record(BrightnessChange{Brightness: msg.Brightness, Source: brightnessSourceWebSocket, ClientID: client.id, Fade: msg.Duration})
+		changeBrightness(hub, msg.Brightness, time.Duration(msg.Duration)*time.Millisecond, client.id)
+	case "adjust-brightness":
+		if msg.ClientID != "" || msg.Zone != "" {
+			sendError(client, "adjust-brightness only applies to the shared brightness, use set-brightness with a clientId or zone")
+			return
+		}
+
+		// The result reaches the sender in the brightness-update broadcast
+		brightness := adjustBrightness(hub, msg.Delta, client.id)
+		log.Printf("Brightness adjusted by %+d to %d", msg.Delta, brightness)
+		brightnessHistory.record(BrightnessChange{Brightness: brightness, Source: brightnessSourceWebSocket, ClientID: client.id})
 	case "get-brightness":
 		brightnessState.mutex.RLock()
 		brightness := brightnessState.value
 		brightnessState.mutex.RUnlock()
 		
-		// Send brightness to requesting client (broadcast to all for simplicity)
-		broadcastBrightness(hub, brightness)
+		// Only the requesting client needs the answer, which it mustn't take for its own echo
+		sendBrightness(client, brightness, updateSourceServer)
 	}
 }
 
-func broadcastBrightness(hub *Hub, brightness int) {
+// setBrightness stores the shared brightness and drives the local backlight
+func setBrightness(brightness int) {
+	brightnessState.mutex.Lock()
+	brightnessState.value = brightness
+	brightnessState.mutex.Unlock()
+
+	backlight.apply(brightness)
+}
+
+// broadcastBrightness sends the shared brightness to every client, source being the ID of the
+// client that changed it or one of the updateSource values
+func broadcastBrightness(hub *Hub, brightness int, source string) {
 	msg := BrightnessMessage{
 		Type:       "brightness-update",
 		Brightness: brightness,
+		Source:     source,
 	}
 	
 	data, err := json.Marshal(msg)
@@ -703,34 +1564,87 @@ func broadcastBrightness(hub *Hub, brightness int) {
 		return
 	}
 	
-	hub.broadcast <- data
+	hub.tryBroadcast(data, "brightness")
+	mqttBridge.publishBrightness(brightness)
+}
+
+// sendBrightness updates a single client's brightness without touching the shared state
+func sendBrightness(client *Client, brightness int, source string) {
+	data, err := json.Marshal(BrightnessMessage{
+		Type:       "brightness-update",
+		Brightness: brightness,
+		Source:     source,
+	})
+	if err != nil {
+		log.Println("Error marshaling brightness message:", err)
+		return
+	}
+
+	sendToClient(client, data)
 }
 
-func handleTabMessage(hub *Hub, msg *TabMessage) {
+func handleTabMessage(hub *Hub, client *Client, msg *TabMessage) {
 	fmt.Println("Received tab message:", msg.Type)
 	switch msg.Type {
 	case "set-tab":
+		if !tabRegistry.valid(msg.Tab) {
+			sendError(client, "Tab must be one of: "+strings.Join(tabRegistry.list(), ", "))
+			return
+		}
+
+		if msg.Zone != "" {
+			if msg.ClientID != "" {
+				sendError(client, errZoneAndClient.Error())
+				return
+			}
+			members, err := zoneManager.members(hub, msg.Zone)
+			if err != nil {
+				sendError(client, err.Error())
+				return
+			}
+			log.Printf("Tab set to %s for zone %s (%d connected)", msg.Tab, msg.Zone, len(members))
+			for _, member := range members {
+				sendTab(member, msg.Tab, client.id)
+			}
+			return
+		}
+
+		if msg.ClientID != "" {
+			target := hub.findClient(msg.ClientID)
+			if target == nil {
+				sendError(client, fmt.Sprintf("Client %s not connected", msg.ClientID))
+				return
+			}
+			log.Printf("Tab set to %s for client %s", msg.Tab, target.id)
+			sendTab(target, msg.Tab, client.id)
+			return
+		}
+
 		tabState.mutex.Lock()
 		tabState.value = msg.Tab
 		tabState.mutex.Unlock()
+		tabRotation.noteManualChange()
 		log.Printf("Tab set to %s", msg.Tab)
 		
 		// Broadcast tab update to all clients
-		broadcastTab(hub, msg.Tab)
+		broadcastTab(hub, msg.Tab, client.id)
 	case "get-tab":
 		tabState.mutex.RLock()
 		tab := tabState.value
 		tabState.mutex.RUnlock()
 		
-		// Send tab to requesting client (broadcast to all for simplicity)
-		broadcastTab(hub, tab)
+		// Only the requesting client needs the answer
+		sendTab(client, tab, updateSourceServer)
 	}
 }
 
-func broadcastTab(hub *Hub, tab string) {
+// broadcastTab switches every client's tab, source being the ID of the client that changed it or
+// one of the updateSource values
+func broadcastTab(hub *Hub, tab string, source string) {
 	msg := TabMessage{
-		Type: "tab-update",
-		Tab:  tab,
+		Type:   "tab-update",
+		Tab:    tab,
+		Source: source,
 	}
 	
 	data, err := json.Marshal(msg)
@@ -739,20 +1653,227 @@ func broadcastTab(hub *Hub, tab string) {
 		return
 	}
 	
-	hub.broadcast <- data
+	// Clients switch on tab-update without echoing, so record their new tab here
+	hub.mutex.RLock()
+	for client := range hub.clients {
+		client.mutex.Lock()
+		client.tab = tab
+		client.mutex.Unlock()
+	}
+	hub.mutex.RUnlock()
+
+	hub.tryBroadcast(data, "tab")
+	mqttBridge.publishTab(tab)
+}
+
+// sendTab switches a single client's tab without touching the shared state
+func sendTab(client *Client, tab string, source string) {
+	data, err := json.Marshal(TabMessage{
+		Type:   "tab-update",
+		Tab:    tab,
+		Source: source,
+	})
+	if err != nil {
+		log.Println("Error marshaling tab message:", err)
+		return
+	}
+
+	client.mutex.Lock()
+	client.tab = tab
+	client.mutex.Unlock()
+
+	sendToClient(client, data)
+}
+
+func isValidTimeFormat(format string) bool {
+	return format == "12h" || format == "24h"
+}
+
+// volumeToGain maps a 0-100 volume onto a logarithmic curve spanning 40dB, so each step sounds even
+func volumeToGain(volume int) float64 {
+	if volume <= 0 {
+		return 0
+	}
+	const dynamicRangeDB = 40.0
+	db := dynamicRangeDB * (float64(volume)/100 - 1)
+	return math.Pow(10, db/20)
+}
+
+// applyGain scales a sample, clamping to the int16 range so gains above unity can't wrap around
+func applyGain(sample int16, gain float64) int16 {
+	if gain == 1 {
+		return sample
+	}
+	scaled := float64(sample) * gain
+	if scaled > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if scaled < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(scaled)
+}
+
+func clampPercent(value int) int {
+	if value < 0 {
+		return 0
+	}
+	if value > 100 {
+		return 100
+	}
+	return value
+}
+
+// setVolume clamps and stores the volume, returning the value actually applied
+func setVolume(volume int) int {
+	volume = clampPercent(volume)
+
+	volumeState.mutex.Lock()
+	volumeState.value = volume
+	volumeState.gain = volumeToGain(volume)
+	volumeState.mutex.Unlock()
+
+	return volume
 }
 
-func handleRefreshMessage(client *Client) {
-	// Check cooldown period
+func handleVolumeMessage(hub *Hub, client *Client, msg *VolumeMessage) {
+	switch msg.Type {
+	case "set-volume":
+		volume := setVolume(msg.Volume)
+		log.Printf("Volume set to %d", volume)
+
+		// Broadcast volume update to all clients
+		broadcastVolume(hub, volume)
+	case "get-volume":
+		volumeState.mutex.RLock()
+		volume := volumeState.value
+		volumeState.mutex.RUnlock()
+
+		sendVolume(client, volume)
+	}
+}
+
+func broadcastVolume(hub *Hub, volume int) {
+	msg := VolumeMessage{
+		Type:   "volume-update",
+		Volume: volume,
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling volume message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "volume")
+}
+
+func sendVolume(client *Client, volume int) {
+	data, err := json.Marshal(VolumeMessage{
+		Type:   "volume-update",
+		Volume: volume,
+	})
+	if err != nil {
+		log.Println("Error marshaling volume message:", err)
+		return
+	}
+
+	sendToClient(client, data)
+}
+
+func handleMuteMessage(hub *Hub, client *Client, msg *MuteMessage) {
+	client.mutex.Lock()
+	client.muted = msg.Muted
+	client.mutex.Unlock()
+	log.Printf("Client %s mute set to %t", client.id, msg.Muted)
+
+	// Broadcast mute state so every controller stays in sync, naming the display it belongs to
+	data, err := json.Marshal(MuteMessage{
+		Type:     "mute-update",
+		Muted:    msg.Muted,
+		ClientID: client.id,
+	})
+	if err != nil {
+		log.Println("Error marshaling mute message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "mute")
+}
+
+func handleTimeFormatMessage(hub *Hub, client *Client, msg *TimeFormatMessage) {
+	switch msg.Type {
+	case "set-time-format":
+		if !isValidTimeFormat(msg.Format) {
+			sendError(client, "Time format must be one of: 12h, 24h")
+			return
+		}
+
+		clockFormatState.mutex.Lock()
+		clockFormatState.value = msg.Format
+		clockFormatState.mutex.Unlock()
+		log.Printf("Time format set to %s", msg.Format)
+
+		// Broadcast time format update to all clients
+		broadcastTimeFormat(hub, msg.Format)
+	case "get-time-format":
+		clockFormatState.mutex.RLock()
+		format := clockFormatState.value
+		clockFormatState.mutex.RUnlock()
+
+		sendTimeFormat(client, format)
+	}
+}
+
+func broadcastTimeFormat(hub *Hub, format string) {
+	msg := TimeFormatMessage{
+		Type:   "time-format-update",
+		Format: format,
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling time format message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "time format")
+}
+
+func sendTimeFormat(client *Client, format string) {
+	data, err := json.Marshal(TimeFormatMessage{
+		Type:   "time-format-update",
+		Format: format,
+	})
+	if err != nil {
+		log.Println("Error marshaling time format message:", err)
+		return
+	}
+
+	sendToClient(client, data)
+}
+
+// handleRefreshMessage reloads a client, force skips the cooldown to recover a stuck display. It
+// reports false when the cooldown held the refresh back or it couldn't be sent.
+func handleRefreshMessage(client *Client, force bool) bool {
+	cooldown := client.effectiveRefreshCooldown()
+
+	client.mutex.Lock()
 	if !client.lastRefresh.IsZero() {
 		timeSinceLastRefresh := time.Since(client.lastRefresh)
-		if timeSinceLastRefresh < client.refreshCooldown {
-			log.Printf("Refresh requested but in cooldown (%.0fs remaining)", (client.refreshCooldown - timeSinceLastRefresh).Seconds())
-			return
+		if timeSinceLastRefresh < cooldown {
+			remaining := (cooldown - timeSinceLastRefresh).Seconds()
+			if !force {
+				client.mutex.Unlock()
+				log.Printf("Refresh requested but in cooldown (%.0fs remaining)", remaining)
+				return false
+			}
+			log.Printf("Forced refresh of client %s overrides cooldown (%.0fs remaining)", client.id, remaining)
 		}
 	}
-	
 	client.lastRefresh = time.Now()
+	client.mutex.Unlock()
+	
 	log.Println("Sending refresh command to client")
 	
 	msg := RefreshMessage{
@@ -762,30 +1883,19 @@ func handleRefreshMessage(client *Client) {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		log.Println("Error marshaling refresh message:", err)
-		return
-	}
-	
-	select {
-	case client.send <- data:
-		log.Println("Refresh command sent")
-	default:
-		log.Println("Failed to send refresh command (channel full)")
+		return false
 	}
-}
-
-func handleAutoRefresh(client *Client) {
-	// Wait a bit to see if WebRTC reconnects naturally
-	time.Sleep(5 * time.Second)
 	
-	if !client.webrtcConnected {
-		log.Println("WebRTC still disconnected after 5s, triggering auto-refresh")
-		handleRefreshMessage(client)
+	if !sendPriority(client, data) {
+		return false
 	}
+	log.Println("Refresh command sent")
+	return true
 }
 
 func handleGetBrightness(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 	
@@ -800,33 +1910,78 @@ func handleGetBrightness(w http.ResponseWriter, r *http.Request) {
 
 func handleSetBrightness(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 	
 	var req struct {
-		Brightness int `json:"brightness"`
+		Brightness int    `json:"brightness"`
+		Duration   int    `json:"duration"` // Fade time in milliseconds
+		ClientID   string `json:"clientId"`
+		Zone       string `json:"zone"`
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 	
 	if req.Brightness < 0 || req.Brightness > 100 {
-		http.Error(w, "Brightness must be between 0 and 100", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Brightness must be between 0 and 100")
 		return
 	}
 	
-	brightnessState.mutex.Lock()
-	brightnessState.value = req.Brightness
-	brightnessState.mutex.Unlock()
+	if req.Zone != "" {
+		if req.ClientID != "" {
+			writeJSONError(w, http.StatusBadRequest, errZoneAndClient.Error())
+			return
+		}
+		members, ok := zoneMembersForRequest(w, req.Zone)
+		if !ok {
+			return
+		}
+
+		log.Printf("Brightness set to %d for zone %s via HTTP (%d connected)", req.Brightness, req.Zone, len(members))
+		brightnessHistory.record(BrightnessChange{Brightness: req.Brightness, Source: brightnessSourceHTTP, Zone: req.Zone})
+		for _, member := range members {
+			sendBrightness(member, req.Brightness, updateSourceServer)
+		}
+
+		response := map[string]interface{}{"brightness": req.Brightness, "zone": req.Zone, "clients": len(members)}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if req.ClientID != "" {
+		target := findClientForRequest(w, req.ClientID)
+		if target == nil {
+			return
+		}
+
+		log.Printf("Brightness set to %d for client %s via HTTP", req.Brightness, target.id)
+		brightnessHistory.record(BrightnessChange{Brightness: req.Brightness, Source: brightnessSourceHTTP, Target: target.id})
+		sendBrightness(target, req.Brightness, updateSourceServer)
+
+		response := map[string]interface{}{"brightness": req.Brightness, "clientId": target.id}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
 	
-	log.Printf("Brightness set to %d via HTTP", req.Brightness)
+	if req.Duration < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Duration must not be negative")
+		return
+	}
+	
+	log.Printf("Brightness set to %d via HTTP (fade %dms)", req.Brightness, req.Duration)
+	brightnessHistory.record(BrightnessChange{Brightness: req.Brightness, Source: brightnessSourceHTTP, Fade: req.Duration})
 	
-	// Broadcast brightness update to all WebSocket clients
+	// Update and broadcast brightness to all WebSocket clients
 	if globalHub != nil {
-		broadcastBrightness(globalHub, req.Brightness)
+		changeBrightness(globalHub, req.Brightness, time.Duration(req.Duration)*time.Millisecond, updateSourceServer)
+	} else {
+		setBrightness(req.Brightness)
 	}
 	
 	response := map[string]int{"brightness": req.Brightness}
@@ -834,9 +1989,15 @@ func handleSetBrightness(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func currentTab() string {
+	tabState.mutex.RLock()
+	defer tabState.mutex.RUnlock()
+	return tabState.value
+}
+
 func handleGetTab(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 	
@@ -851,35 +2012,73 @@ func handleGetTab(w http.ResponseWriter, r *http.Request) {
 
 func handleSetTab(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 	
 	var req struct {
-		Tab string `json:"tab"`
+		Tab      string `json:"tab"`
+		ClientID string `json:"clientId"`
+		Zone     string `json:"zone"`
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 	
 	// Validate tab value
-	validTabs := map[string]bool{"clock": true, "audio": true, "settings": true, "info": true}
-	if !validTabs[req.Tab] {
-		http.Error(w, "Tab must be one of: clock, audio, settings, info", http.StatusBadRequest)
+	if !tabRegistry.valid(req.Tab) {
+		writeJSONError(w, http.StatusBadRequest, "Tab must be one of: "+strings.Join(tabRegistry.list(), ", "))
+		return
+	}
+	
+	if req.Zone != "" {
+		if req.ClientID != "" {
+			writeJSONError(w, http.StatusBadRequest, errZoneAndClient.Error())
+			return
+		}
+		members, ok := zoneMembersForRequest(w, req.Zone)
+		if !ok {
+			return
+		}
+
+		log.Printf("Tab set to %s for zone %s via HTTP (%d connected)", req.Tab, req.Zone, len(members))
+		for _, member := range members {
+			sendTab(member, req.Tab, updateSourceServer)
+		}
+
+		response := map[string]interface{}{"tab": req.Tab, "zone": req.Zone, "clients": len(members)}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if req.ClientID != "" {
+		target := findClientForRequest(w, req.ClientID)
+		if target == nil {
+			return
+		}
+
+		log.Printf("Tab set to %s for client %s via HTTP", req.Tab, target.id)
+		sendTab(target, req.Tab, updateSourceServer)
+
+		response := map[string]string{"tab": req.Tab, "clientId": target.id}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 	
 	tabState.mutex.Lock()
 	tabState.value = req.Tab
 	tabState.mutex.Unlock()
+	tabRotation.noteManualChange()
 	
 	log.Printf("Tab set to %s via HTTP", req.Tab)
 	
 	// Broadcast tab update to all WebSocket clients
 	if globalHub != nil {
-		broadcastTab(globalHub, req.Tab)
+		broadcastTab(globalHub, req.Tab, updateSourceServer)
 	}
 	
 	response := map[string]string{"tab": req.Tab}
@@ -887,9 +2086,142 @@ func handleSetTab(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+func handleVolume(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Volume int `json:"volume"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		volume := setVolume(req.Volume)
+		log.Printf("Volume set to %d via HTTP", volume)
+
+		// Broadcast volume update to all WebSocket clients
+		if globalHub != nil {
+			broadcastVolume(globalHub, volume)
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	volumeState.mutex.RLock()
+	volume := volumeState.value
+	volumeState.mutex.RUnlock()
+
+	response := map[string]int{"volume": volume}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func handleTimeFormat(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Format string `json:"format"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if !isValidTimeFormat(req.Format) {
+			writeJSONError(w, http.StatusBadRequest, "Format must be one of: 12h, 24h")
+			return
+		}
+
+		clockFormatState.mutex.Lock()
+		clockFormatState.value = req.Format
+		clockFormatState.mutex.Unlock()
+
+		log.Printf("Time format set to %s via HTTP", req.Format)
+
+		// Broadcast time format update to all WebSocket clients
+		if globalHub != nil {
+			broadcastTimeFormat(globalHub, req.Format)
+		}
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	clockFormatState.mutex.RLock()
+	format := clockFormatState.value
+	clockFormatState.mutex.RUnlock()
+
+	response := map[string]string{"format": format}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// findClientForRequest looks up a targeted client, replying 404 when it isn't connected
+func findClientForRequest(w http.ResponseWriter, id string) *Client {
+	var client *Client
+	if globalHub != nil {
+		client = globalHub.findClient(id)
+	}
+	if client == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Client %s not connected", id))
+	}
+	return client
+}
+
 func handleRefresh(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	
+	// The body is optional, an empty one refreshes every client
+	var req struct {
+		ClientID string `json:"clientId"`
+		Zone     string `json:"zone"`
+		Force    bool   `json:"force"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	
+	if req.Zone != "" {
+		if req.ClientID != "" {
+			writeJSONError(w, http.StatusBadRequest, errZoneAndClient.Error())
+			return
+		}
+		members, ok := zoneMembersForRequest(w, req.Zone)
+		if !ok {
+			return
+		}
+
+		log.Printf("Refresh requested for zone %s via HTTP (%d connected)", req.Zone, len(members))
+		for _, member := range members {
+			go handleRefreshMessage(member, req.Force)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "refresh sent", "zone": req.Zone, "clients": len(members)})
+		return
+	}
+
+	if req.ClientID != "" {
+		target := findClientForRequest(w, req.ClientID)
+		if target == nil {
+			return
+		}
+
+		log.Printf("Refresh requested for client %s via HTTP", target.id)
+		go handleRefreshMessage(target, req.Force)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "refresh sent", "clientId": target.id})
 		return
 	}
 	
@@ -899,7 +2231,7 @@ func handleRefresh(w http.ResponseWriter, r *http.Request) {
 	if globalHub != nil {
 		globalHub.mutex.RLock()
 		for client := range globalHub.clients {
-			go handleRefreshMessage(client)
+			go handleRefreshMessage(client, req.Force)
 		}
 		globalHub.mutex.RUnlock()
 	}
@@ -908,44 +2240,259 @@ func handleRefresh(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "refresh sent"})
 }
 
+// bindAddr restricts the listeners to one interface, e.g. 127.0.0.1 behind a reverse proxy
+// (BIND_ADDR, empty = all interfaces)
+var bindAddr = strings.Trim(os.Getenv("BIND_ADDR"), "[]")
+
+// listenNetwork picks the address family of the listeners (LISTEN_NETWORK): "tcp" listens
+// dual-stack on IPv4 and IPv6 where the OS allows it, "tcp4" and "tcp6" on one family only
+var listenNetwork = os.Getenv("LISTEN_NETWORK")
+
+// listen opens a listener on bindAddr and the port, exiting if the address or network is unusable
+func listen(port string) net.Listener {
+	network := listenNetwork
+	switch network {
+	case "":
+		network = "tcp"
+	case "tcp", "tcp4", "tcp6":
+	default:
+		log.Fatalf("Invalid LISTEN_NETWORK %q, use tcp, tcp4 or tcp6", network)
+	}
+
+	addr := net.JoinHostPort(bindAddr, port)
+	if _, err := net.ResolveTCPAddr(network, addr); err != nil {
+		log.Fatalf("Invalid listen address %q for %s (check BIND_ADDR, LISTEN_NETWORK and the port): %v", addr, network, err)
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s (%s): %v", addr, network, err)
+	}
+	log.Printf("Listening on %s (%s)", listener.Addr(), network)
+	return listener
+}
+
 func main() {
+	startTime = time.Now()
+
+	// Load the saved config before anything reads the timezone or audio settings
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile == "" {
+		configFile = "config.json"
+	}
+	serverConfig = newConfigStore(configFile)
+	if err := serverConfig.load(); err != nil {
+		log.Printf("Failed to load config from %s: %v", configFile, err)
+	}
+
 	hub := newHub()
 	globalHub = hub // Store hub globally for HTTP handlers
+	mqttBridge = newMQTTBridge(hub)
 	go hub.run()
+	go handleShutdown(hub)
+	go broadcastTime(hub)
 
-	// Serve static files
-	fs := http.FileServer(http.Dir("./static"))
-	http.Handle("/", fs)
+	// Load persisted alarms and start checking them
+	alarmsFile := os.Getenv("ALARMS_FILE")
+	if alarmsFile == "" {
+		alarmsFile = "alarms.json"
+	}
+	alarmStore = newAlarmStore(alarmsFile)
+	if err := alarmStore.load(); err != nil {
+		log.Printf("Failed to load alarms from %s: %v", alarmsFile, err)
+	}
+	go runAlarms(hub)
+
+	// Load the do-not-disturb window consulted by alarms and banners
+	quietHoursFile := os.Getenv("QUIET_HOURS_FILE")
+	if quietHoursFile == "" {
+		quietHoursFile = "quiet_hours.json"
+	}
+	quietHours = newQuietHours(quietHoursFile)
+	if err := quietHours.load(); err != nil {
+		log.Printf("Failed to load quiet hours from %s: %v", quietHoursFile, err)
+	}
+
+	// Load the zones grouping clients for targeted commands
+	zonesFile := os.Getenv("ZONES_FILE")
+	if zonesFile == "" {
+		zonesFile = "zones.json"
+	}
+	zoneManager = newZoneManager(zonesFile)
+	if err := zoneManager.load(); err != nil {
+		log.Printf("Failed to load zones from %s: %v", zonesFile, err)
+	}
+
+	// Load the displays that turned their audio off
+	audioPrefsFile := os.Getenv("AUDIO_PREFS_FILE")
+	if audioPrefsFile == "" {
+		audioPrefsFile = "audio_prefs.json"
+	}
+	audioPreferences = newAudioPreferences(audioPrefsFile)
+	if err := audioPreferences.load(); err != nil {
+		log.Printf("Failed to load audio preferences from %s: %v", audioPrefsFile, err)
+	}
 
-	// WebSocket endpoint
+	// Load custom tabs registered at runtime
+	tabsFile := os.Getenv("TABS_FILE")
+	if tabsFile == "" {
+		tabsFile = "tabs.json"
+	}
+	tabRegistry = newTabRegistry(tabsFile)
+	if err := tabRegistry.load(); err != nil {
+		log.Printf("Failed to load tabs from %s: %v", tabsFile, err)
+	}
+
+	go runBrightnessSchedule(hub)
+	go runLightSensor(hub)
+	go runTabRotation(hub)
+	go runSunTimes(hub)
+	go runWeather(hub)
+	go runWebhooks()
+	go watchSnapclient(hub)
+	go runAudioListenersNotifier(hub)
+
+	// A server saved through /api/snap/config replaces the one start.sh launched snapclient with
+	if saved, err := snapController.load(); err != nil {
+		log.Printf("Failed to load Snapcast config: %v", err)
+	} else if saved {
+		go func() {
+			if err := snapController.control("restart"); err != nil {
+				log.Printf("Failed to restart snapclient with saved config: %v", err)
+			}
+		}()
+	}
+
+	loadICEServersFromEnv()
+
+	if apiToken != "" {
+		log.Println("API token authentication enabled")
+	}
+
+	// Serve static files, gzipped for clients that support it and with caching headers
+	files := staticFiles()
+	http.Handle("/", gzipStatic(cacheStatic(files, http.FileServer(http.FS(files)))))
+
+	// WebSocket endpoint, checks the token itself to report failures with a close code
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		handleWebSocket(hub, w, r)
 	})
 
+	// Health endpoints
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+
 	// Snapclient status endpoint
-	http.HandleFunc("/api/snap/status", handleSnapStatus)
+	http.HandleFunc("/api/snap/status", protect(handleSnapStatus))
+	http.HandleFunc("/api/snap/start", requireConfiguredToken(handleSnapControl))
+	http.HandleFunc("/api/snap/stop", requireConfiguredToken(handleSnapControl))
+	http.HandleFunc("/api/snap/restart", requireConfiguredToken(handleSnapControl))
+	http.HandleFunc("/api/snap/config", requireConfiguredToken(handleSnapConfig))
+
+	// Connected clients endpoint
+	http.HandleFunc("/api/clients", protect(handleClients))
+	http.HandleFunc("/api/zones", protect(handleZones))
+
+	// Prometheus metrics endpoint
+	http.HandleFunc("/metrics", protect(handleMetrics))
 
 	// Config endpoint
-	http.HandleFunc("/api/config", handleConfig)
+	http.HandleFunc("/api/config", protect(handleConfig))
+	http.HandleFunc("/api/version", protect(handleVersion))
+	http.HandleFunc("/api/time-status", protect(handleTimeStatus))
+	http.HandleFunc("/api/worldclocks", protect(handleWorldClocks))
+	http.HandleFunc("/api/suntimes", protect(handleSunTimes))
+	http.HandleFunc("/api/weather", protect(handleWeather))
 
 	// Brightness endpoints
-	http.HandleFunc("/api/brightness", handleGetBrightness)
-	http.HandleFunc("/api/brightness/set", handleSetBrightness)
+	http.HandleFunc("/api/brightness", protect(handleGetBrightness))
+	http.HandleFunc("/api/brightness/set", protect(handleSetBrightness))
+	http.HandleFunc("/api/brightness/adjust", protect(handleAdjustBrightness))
+	http.HandleFunc("/api/brightness/schedule", protect(handleBrightnessSchedule))
+	http.HandleFunc("/api/brightness/history", protect(handleBrightnessHistory))
+	http.HandleFunc("/api/light", protect(handleLightSensor))
 
 	// Tab endpoints
-	http.HandleFunc("/api/tab", handleGetTab)
-	http.HandleFunc("/api/tab/set", handleSetTab)
+	http.HandleFunc("/api/tab", protect(handleGetTab))
+	http.HandleFunc("/api/tab/set", protect(handleSetTab))
+	http.HandleFunc("/api/tabs", protect(handleTabs))
+	http.HandleFunc("/api/tab/rotation", protect(handleTabRotation))
+
+	// Alarm endpoints
+	http.HandleFunc("/api/alarms", protect(handleAlarms))
+	http.HandleFunc("/api/alarms/", protect(handleAlarm))
+
+	// Audio config endpoint
+	http.HandleFunc("/api/audio/config", protect(handleAudioConfig))
+	http.HandleFunc("/api/audio/devices", protect(handleAudioDevices))
+	http.HandleFunc("/api/audio/device", protect(handleSetAudioDevice))
+	http.HandleFunc("/api/audio/stream", protect(handleAudioStream))
+	http.HandleFunc("/api/audio/stream.mp3", protect(handleMP3Stream))
+
+	// WebRTC endpoints
+	http.HandleFunc("/api/webrtc/ice-servers", protect(handleICEServers))
+	http.HandleFunc("/api/webrtc/stats", protect(handleWebRTCStats))
+	http.HandleFunc("/api/audio/buffers", protect(handleAudioBuffers))
+	http.HandleFunc("/api/audio/stats", protect(handleAudioStats))
+	http.HandleFunc("/api/audio/record", protect(handleRecordingStatus))
+	http.HandleFunc("/api/audio/record/start", protect(handleRecordingStart))
+	http.HandleFunc("/api/audio/record/stop", protect(handleRecordingStop))
+	http.HandleFunc("/api/audio/listeners", protect(handleAudioListeners))
+	http.HandleFunc("/api/banner", protect(handleBanner))
+	http.HandleFunc("/api/quiet-hours", protect(handleQuietHours))
+
+	// Volume endpoint
+	http.HandleFunc("/api/volume", protect(handleVolume))
+	http.HandleFunc("/api/balance", protect(handleBalance))
+
+	// Time format endpoint
+	http.HandleFunc("/api/time-format", protect(handleTimeFormat))
 
 	// Refresh endpoint
-	http.HandleFunc("/api/refresh", handleRefresh)
+	http.HandleFunc("/api/refresh", protect(handleRefresh))
+	http.HandleFunc("/api/refresh/cooldown", protect(handleRefreshCooldown))
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Printf("Smart Clock server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal("ListenAndServe error:", err)
+	handler := withCORS(http.DefaultServeMux)
+
+	tlsSettings := tlsSettingsFromEnv()
+	if !tlsSettings.enabled() {
+		listener := listen(port)
+		log.Printf("Smart Clock server starting on %s", listener.Addr())
+		if err := http.Serve(listener, handler); err != nil {
+			log.Fatal("Serve error:", err)
+		}
+		return
+	}
+
+	listener := listen(tlsSettings.Port)
+	server := &http.Server{Handler: handler}
+	if tlsSettings.SelfSigned {
+		cert, err := generateSelfSignedCert()
+		if err != nil {
+			log.Fatal("Failed to generate self-signed certificate:", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		log.Println("Using generated self-signed certificate")
+	}
+
+	if tlsSettings.Redirect {
+		redirectListener := listen(port)
+		go func() {
+			log.Printf("Redirecting HTTP on %s to HTTPS", redirectListener.Addr())
+			if err := http.Serve(redirectListener, redirectToHTTPS(tlsSettings.Port)); err != nil {
+				log.Printf("HTTP redirect listener error: %v", err)
+			}
+		}()
+	}
+
+	log.Printf("Smart Clock server starting with TLS on %s", listener.Addr())
+	// Cert and key files are ignored when TLSConfig already holds a certificate
+	if err := server.ServeTLS(listener, tlsSettings.CertFile, tlsSettings.KeyFile); err != nil {
+		log.Fatal("ServeTLS error:", err)
 	}
 }
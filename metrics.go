@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Counters exported on /metrics
+var (
+	opusPacketsEncoded    atomic.Uint64 // Once per frame and codec by the shared encoder, G.711 included
+	opusPacketsSent       atomic.Uint64 // Once per frame and track
+	sourceFramesDropped   atomic.Uint64 // Multiplexer source channel full
+	listenerFramesDropped atomic.Uint64 // A listener's channel full
+	silenceFramesGated    atomic.Uint64 // Held back from gated listeners by AUDIO_SILENCE_GATE
+
+	websocketMessagesDropped atomic.Uint64 // A client's send buffer full
+)
+
+// Broadcasts dropped because the hub's queue was full, by kind of update
+var broadcastsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "smartclock_broadcasts_dropped_total",
+	Help: "Broadcasts dropped because the hub's broadcast queue was full.",
+}, []string{"kind"})
+
+// The collectors read the counters and live state when scraped, so the hot paths only pay for an
+// atomic add
+func init() {
+	counter := func(name, help string, value *atomic.Uint64, labels prometheus.Labels) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help, ConstLabels: labels},
+			func() float64 { return float64(value.Load()) })
+	}
+	gauge := func(name, help string, value func() float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, value)
+	}
+	const framesDroppedHelp = "PCM frames dropped because a channel was full."
+
+	prometheus.MustRegister(
+		gauge("smartclock_websocket_clients", "Connected WebSocket clients.", func() float64 {
+			clients, _ := countClients()
+			return float64(clients)
+		}),
+		gauge("smartclock_websocket_connections", "Open WebSocket connections counted against MAX_WS_CONNECTIONS.", func() float64 {
+			return float64(activeWebSocketConnections.Load())
+		}),
+		gauge("smartclock_websocket_connection_limit", "Maximum concurrent WebSocket connections (MAX_WS_CONNECTIONS).", func() float64 {
+			return float64(maxWebSocketConnections)
+		}),
+		gauge("smartclock_webrtc_connections", "Established WebRTC peer connections.", func() float64 {
+			_, webrtcConnections := countClients()
+			return float64(webrtcConnections)
+		}),
+		gauge("smartclock_audio_listeners", "Streams subscribed to the audio multiplexer.", func() float64 {
+			return float64(audioMultiplexer.listenerCount())
+		}),
+		counter("smartclock_opus_packets_encoded_total", "Audio packets encoded (Opus or G.711).", &opusPacketsEncoded, nil),
+		counter("smartclock_opus_packets_sent_total", "Audio packets written to WebRTC tracks.", &opusPacketsSent, nil),
+		counter("smartclock_audio_frames_dropped_total", framesDroppedHelp, &sourceFramesDropped, prometheus.Labels{"stage": "source"}),
+		counter("smartclock_audio_frames_dropped_total", framesDroppedHelp, &listenerFramesDropped, prometheus.Labels{"stage": "listener"}),
+		counter("smartclock_audio_frames_gated_total", "Silent PCM frames held back from gated listeners.", &silenceFramesGated, nil),
+		counter("smartclock_websocket_messages_dropped_total", "WebSocket messages dropped because a client's send buffer was full.", &websocketMessagesDropped, nil),
+		broadcastsDropped,
+		gauge("smartclock_brightness", "Current display brightness (0-100).", func() float64 {
+			brightnessState.mutex.RLock()
+			defer brightnessState.mutex.RUnlock()
+			return float64(brightnessState.value)
+		}),
+	)
+}
+
+// countClients returns the connected clients and how many of them have an established WebRTC
+// connection
+func countClients() (clients, webrtcConnections int) {
+	if globalHub == nil {
+		return 0, 0
+	}
+
+	globalHub.mutex.RLock()
+	defer globalHub.mutex.RUnlock()
+
+	for client := range globalHub.clients {
+		client.mutex.RLock()
+		peerConnection := client.peerConnection
+		client.mutex.RUnlock()
+		if peerConnection != nil && peerConnection.ConnectionState() == webrtc.PeerConnectionStateConnected {
+			webrtcConnections++
+		}
+	}
+	return len(globalHub.clients), webrtcConnections
+}
+
+var metricsHandler = promhttp.Handler()
+
+// handleMetrics serves the Prometheus metrics, along with the Go runtime and process collectors
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	metricsHandler.ServeHTTP(w, r)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// mp3Bitrate is the MP3 stream bitrate in kbps, from MP3_BITRATE (default 128)
+var mp3Bitrate = envInt("MP3_BITRATE", 128)
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// mp3Encoder is one running ffmpeg process fed from the audio multiplexer
+type mp3Encoder struct {
+	cmd  *exec.Cmd
+	stop chan struct{}
+}
+
+// MP3Streamer shares a single ffmpeg encoder between all HTTP MP3 listeners
+type MP3Streamer struct {
+	listeners map[chan []byte]bool
+	encoder   *mp3Encoder
+	mutex     sync.Mutex
+}
+
+var mp3Streamer = &MP3Streamer{
+	listeners: make(map[chan []byte]bool),
+}
+
+func (m *MP3Streamer) subscribe() (chan []byte, error) {
+	m.mutex.Lock()
+	started := m.encoder == nil
+	if started {
+		if err := m.startLocked(); err != nil {
+			m.mutex.Unlock()
+			return nil, err
+		}
+	}
+
+	ch := make(chan []byte, 64)
+	m.listeners[ch] = true
+	log.Printf("MP3 listener subscribed (%d active)", len(m.listeners))
+	notifyAudioListeners()
+	m.mutex.Unlock()
+
+	if started {
+		startMP3Capture()
+	}
+	return ch, nil
+}
+
+func (m *MP3Streamer) unsubscribe(ch chan []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.listeners, ch)
+	close(ch)
+	log.Printf("MP3 listener unsubscribed (%d active)", len(m.listeners))
+	notifyAudioListeners()
+
+	// Last listener gone, stop the encoder
+	if len(m.listeners) == 0 && m.encoder != nil {
+		close(m.encoder.stop)
+		m.encoder.cmd.Process.Kill()
+		m.encoder = nil
+	}
+}
+
+// startLocked spawns ffmpeg and the goroutines feeding and draining it, the caller holds the mutex
+// and calls startMP3Capture once it is released
+func (m *MP3Streamer) startLocked() error {
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-f", "s16le",
+		"-ar", strconv.Itoa(captureSampleRate),
+		"-ac", strconv.Itoa(captureChannels),
+		"-i", "pipe:0",
+		"-f", "mp3",
+		"-b:a", fmt.Sprintf("%dk", mp3Bitrate),
+		"pipe:1",
+	)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	encoder := &mp3Encoder{cmd: cmd, stop: make(chan struct{})}
+	m.encoder = encoder
+
+	pcm := audioMultiplexer.subscribe("mp3")
+	go m.feed(encoder, pcm, stdin)
+	go m.drain(encoder, stdout)
+
+	log.Printf("MP3 encoder started at %dkbps", mp3Bitrate)
+	return nil
+}
+
+// startMP3Capture starts the audio capture for a new encoder. It runs without the mutex since the
+// source's retries can take seconds, and the encoder's multiplexer subscription already keeps an
+// idle shutdown from racing it.
+func startMP3Capture() {
+	if err := ensureAudioCapture(); err != nil {
+		log.Printf("Failed to start audio capture for MP3 stream: %v", err)
+	}
+}
+
+// feed copies PCM from the multiplexer into ffmpeg until the encoder is stopped
+func (m *MP3Streamer) feed(encoder *mp3Encoder, pcm chan []byte, stdin io.WriteCloser) {
+	defer func() {
+		audioMultiplexer.unsubscribe(pcm)
+		stdin.Close()
+	}()
+
+	for {
+		select {
+		case <-encoder.stop:
+			return
+		case frame := <-pcm:
+			if _, err := stdin.Write(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// drain fans ffmpeg's output out to listeners and restarts ffmpeg if it dies while in use
+func (m *MP3Streamer) drain(encoder *mp3Encoder, stdout io.Reader) {
+	buffer := make([]byte, 4096)
+	for {
+		n, err := stdout.Read(buffer)
+		if n > 0 {
+			chunk := append([]byte(nil), buffer[:n]...)
+			m.mutex.Lock()
+			for ch := range m.listeners {
+				select {
+				case ch <- chunk:
+				default:
+					// Slow listener, skip this chunk
+				}
+			}
+			m.mutex.Unlock()
+		}
+		if err != nil {
+			break
+		}
+	}
+	encoder.cmd.Wait()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.encoder != encoder {
+		// Stopped on purpose
+		return
+	}
+
+	log.Println("MP3 encoder exited unexpectedly")
+	close(encoder.stop)
+	m.encoder = nil
+
+	if len(m.listeners) > 0 {
+		time.AfterFunc(time.Second, m.restart)
+	}
+}
+
+func (m *MP3Streamer) restart() {
+	m.mutex.Lock()
+	if m.encoder != nil || len(m.listeners) == 0 {
+		m.mutex.Unlock()
+		return
+	}
+
+	err := m.startLocked()
+	m.mutex.Unlock()
+	if err != nil {
+		log.Printf("Failed to restart MP3 encoder: %v", err)
+		time.AfterFunc(5*time.Second, m.restart)
+		return
+	}
+	startMP3Capture()
+}
+
+// handleMP3Stream serves an icecast-style MP3 stream
+func handleMP3Stream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	ch, err := mp3Streamer.subscribe()
+	if err != nil {
+		log.Printf("Failed to start MP3 encoder: %v", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "MP3 encoder unavailable")
+		return
+	}
+	defer mp3Streamer.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("icy-name", "Smart Clock")
+	w.Header().Set("icy-br", strconv.Itoa(mp3Bitrate))
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case chunk, ok := <-ch:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttPublishTimeout bounds how long the publisher waits for the broker to take a state update
+const mqttPublishTimeout = 2 * time.Second
+
+// MQTTBridge mirrors the clock state to MQTT topics for Home Assistant and applies commands sent
+// to their /set topics. Everything lives under MQTT_TOPIC_PREFIX:
+//
+//	<prefix>/status                    online/offline, retained, offline set as the last will
+//	<prefix>/brightness                Shared brightness 0-100, retained
+//	<prefix>/tab                       Shared tab, retained
+//	<prefix>/audio/listeners           The audio-listeners message as JSON, retained
+//	<prefix>/brightness/set            Command: brightness 0-100
+//	<prefix>/tab/set                   Command: tab name
+//	<prefix>/refresh                   Command: reload every display, "force" skips the cooldown
+type MQTTBridge struct {
+	client mqtt.Client
+	prefix string
+	hub    *Hub
+
+	// State waiting for the publisher goroutine, the latest payload by topic. The broadcast helpers
+	// only record it here so they never wait on the broker.
+	pending map[string]string
+	wake    chan struct{}
+	mutex   sync.Mutex
+}
+
+var mqttBridge *MQTTBridge
+
+// newMQTTBridge returns nil unless MQTT_BROKER is set, e.g. tcp://homeassistant.local:1883.
+// Connecting happens in the background and is retried until the broker is reachable, after which
+// the client reconnects on its own.
+func newMQTTBridge(hub *Hub) *MQTTBridge {
+	broker := os.Getenv("MQTT_BROKER")
+	if broker == "" {
+		return nil
+	}
+
+	prefix := strings.TrimSuffix(os.Getenv("MQTT_TOPIC_PREFIX"), "/")
+	if prefix == "" {
+		prefix = "smartclock"
+	}
+	clientID := os.Getenv("MQTT_CLIENT_ID")
+	if clientID == "" {
+		clientID = "smartclock"
+	}
+
+	mb := &MQTTBridge{prefix: prefix, hub: hub, pending: make(map[string]string), wake: make(chan struct{}, 1)}
+	options := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(os.Getenv("MQTT_USERNAME")).
+		SetPassword(os.Getenv("MQTT_PASSWORD")).
+		SetWill(prefix+"/status", "offline", 1, true).
+		SetOrderMatters(false). // Commands end in a publish, which can't wait on an ordered router
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(mb.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("MQTT connection lost, reconnecting: %v", err)
+		})
+	mb.client = mqtt.NewClient(options)
+
+	log.Printf("Connecting to MQTT broker %s with topic prefix %s", broker, prefix)
+	mb.client.Connect()
+	go mb.runPublisher()
+	return mb
+}
+
+// onConnect runs after every (re)connect, a clean session needs the subscriptions again and the
+// retained state may have been cleared while the clock was away
+func (mb *MQTTBridge) onConnect(client mqtt.Client) {
+	log.Println("Connected to MQTT broker")
+
+	handlers := map[string]mqtt.MessageHandler{
+		mb.prefix + "/brightness/set": mb.handleBrightness,
+		mb.prefix + "/tab/set":        mb.handleTab,
+		mb.prefix + "/refresh":        mb.handleRefresh,
+	}
+	for topic, handler := range handlers {
+		if token := client.Subscribe(topic, 1, handler); token.Wait() && token.Error() != nil {
+			log.Printf("Failed to subscribe to MQTT topic %s: %v", topic, token.Error())
+		}
+	}
+
+	mb.publish("status", "online")
+	brightnessState.mutex.RLock()
+	brightness := brightnessState.value
+	brightnessState.mutex.RUnlock()
+	mb.publishBrightness(brightness)
+	mb.publishTab(currentTab())
+	mb.publishAudioListeners(currentAudioListeners())
+}
+
+// publish queues a retained state message without blocking. A newer state for the same topic
+// replaces one that hasn't been sent yet.
+func (mb *MQTTBridge) publish(topic string, payload string) {
+	if mb == nil {
+		return
+	}
+
+	mb.mutex.Lock()
+	mb.pending[topic] = payload
+	mb.mutex.Unlock()
+
+	select {
+	case mb.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runPublisher sends the queued state until the process exits
+func (mb *MQTTBridge) runPublisher() {
+	for range mb.wake {
+		mb.mutex.Lock()
+		pending := mb.pending
+		mb.pending = make(map[string]string)
+		mb.mutex.Unlock()
+
+		for topic, payload := range pending {
+			mb.send(topic, payload)
+		}
+	}
+}
+
+// send publishes a retained state message and waits for the broker, dropped while disconnected
+// since onConnect republishes
+func (mb *MQTTBridge) send(topic string, payload string) {
+	if !mb.client.IsConnectionOpen() {
+		return
+	}
+
+	token := mb.client.Publish(mb.prefix+"/"+topic, 0, true, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		log.Printf("MQTT publish to %s timed out", topic)
+	} else if token.Error() != nil {
+		log.Printf("MQTT publish to %s failed: %v", topic, token.Error())
+	}
+}
+
+// close marks the clock offline and disconnects, the broker only sends the will on a lost connection
+func (mb *MQTTBridge) close() {
+	if mb == nil {
+		return
+	}
+
+	// Sent directly, the publisher goroutine may not get to it before the process exits
+	mb.send("status", "offline")
+	mb.client.Disconnect(250)
+}
+
+func (mb *MQTTBridge) publishBrightness(brightness int) {
+	mb.publish("brightness", strconv.Itoa(brightness))
+}
+
+func (mb *MQTTBridge) publishTab(tab string) {
+	mb.publish("tab", tab)
+}
+
+func (mb *MQTTBridge) publishAudioListeners(listeners AudioListenersMessage) {
+	if mb == nil {
+		return
+	}
+
+	data, err := json.Marshal(listeners)
+	if err != nil {
+		log.Println("Error marshaling audio listeners message:", err)
+		return
+	}
+	mb.publish("audio/listeners", string(data))
+}
+
+func (mb *MQTTBridge) handleBrightness(_ mqtt.Client, msg mqtt.Message) {
+	brightness, err := strconv.Atoi(strings.TrimSpace(string(msg.Payload())))
+	if err != nil || brightness < 0 || brightness > 100 {
+		log.Printf("Ignoring MQTT brightness %q, expected 0-100", msg.Payload())
+		return
+	}
+
+	log.Printf("Brightness set to %d via MQTT", brightness)
+	brightnessHistory.record(BrightnessChange{Brightness: brightness, Source: brightnessSourceMQTT})
+	changeBrightness(mb.hub, brightness, 0, updateSourceServer)
+}
+
+func (mb *MQTTBridge) handleTab(_ mqtt.Client, msg mqtt.Message) {
+	tab := strings.TrimSpace(string(msg.Payload()))
+	if !tabRegistry.valid(tab) {
+		log.Printf("Ignoring MQTT tab %q, expected one of: %s", tab, strings.Join(tabRegistry.list(), ", "))
+		return
+	}
+
+	tabState.mutex.Lock()
+	tabState.value = tab
+	tabState.mutex.Unlock()
+	tabRotation.noteManualChange()
+
+	log.Printf("Tab set to %s via MQTT", tab)
+	broadcastTab(mb.hub, tab, updateSourceServer)
+}
+
+func (mb *MQTTBridge) handleRefresh(_ mqtt.Client, msg mqtt.Message) {
+	force := strings.TrimSpace(string(msg.Payload())) == "force"
+	log.Println("Refresh requested via MQTT")
+
+	mb.hub.mutex.RLock()
+	for client := range mb.hub.clients {
+		go handleRefreshMessage(client, force)
+	}
+	mb.hub.mutex.RUnlock()
+}
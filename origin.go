@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// allowedOrigins comes from ALLOWED_ORIGINS, a comma-separated list of origins
+// ("http://clock.lan:8080"), hosts ("clock.lan") or wildcard subdomains ("*.home.lan").
+// An empty list allows every origin.
+var allowedOrigins = parseOriginList(os.Getenv("ALLOWED_ORIGINS"))
+
+func parseOriginList(value string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		if origin != "" {
+			origins = append(origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	return origins
+}
+
+// originAllowed reports whether origin matches one of the allowlist entries
+func originAllowed(origin string, allowlist []string) bool {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	full := u.Scheme + "://" + u.Host
+	hostname := u.Hostname()
+
+	for _, entry := range allowlist {
+		switch {
+		case strings.Contains(entry, "://"):
+			if entry == full {
+				return true
+			}
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(hostname, entry[1:]) {
+				return true
+			}
+		case entry == u.Host || entry == hostname:
+			return true
+		}
+	}
+	return false
+}
+
+func checkOrigin(r *http.Request) bool {
+	if len(allowedOrigins) == 0 {
+		return true
+	}
+
+	// Non-browser clients don't send an Origin
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if !originAllowed(origin, allowedOrigins) {
+		log.Printf("Rejected WebSocket connection from origin %q", origin)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPeerConnections caps concurrent WebRTC connections (MAX_PEER_CONNECTIONS). They share one
+// encoder, but each still costs its own ICE, DTLS and SRTP work.
+var maxPeerConnections = envInt("MAX_PEER_CONNECTIONS", 8)
+
+// activePeerConnections counts peer connections holding a slot, from offer until close
+var activePeerConnections atomic.Int32
+
+type WebRTCRejectedMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Limit   int    `json:"limit"`
+}
+
+// acquirePeerSlot reserves a slot and returns the function giving it back, which is safe to
+// call more than once. It returns nil when the limit is reached.
+func acquirePeerSlot() func() {
+	return acquireSlot(&activePeerConnections, maxPeerConnections)
+}
+
+// acquireSlot increments active unless it has reached limit, see acquirePeerSlot
+func acquireSlot(active *atomic.Int32, limit int) func() {
+	for {
+		current := active.Load()
+		if int(current) >= limit {
+			return nil
+		}
+		if active.CompareAndSwap(current, current+1) {
+			break
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { active.Add(-1) })
+	}
+}
+
+func sendWebRTCRejected(client *Client) {
+	data, err := json.Marshal(WebRTCRejectedMessage{
+		Type:    "webrtc-rejected",
+		Message: fmt.Sprintf("Audio stream limit reached (%d concurrent connections), try again later", maxPeerConnections),
+		Limit:   maxPeerConnections,
+	})
+	if err != nil {
+		log.Println("Error marshaling webrtc-rejected message:", err)
+		return
+	}
+	sendPriority(client, data)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// Versions of the WebSocket JSON contract the server speaks. Bump maxProtocolVersion when message
+// shapes change, keeping the old shapes for clients below it, and raise minProtocolVersion once
+// frontends that old are no longer served.
+const (
+	minProtocolVersion = 1
+	maxProtocolVersion = 1
+)
+
+// closeProtocolUnsupported closes connections from clients whose protocol version is too old,
+// from the 4000-4999 range reserved for applications
+const closeProtocolUnsupported = 4001
+
+// negotiateProtocol picks the version for a connection from ?protocol=, the newest version the
+// client speaks. Clients predating the handshake don't send it and speak version 1.
+func negotiateProtocol(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("protocol")
+	if value == "" {
+		return 1, nil
+	}
+
+	requested, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol version %q", value)
+	}
+	if requested < minProtocolVersion {
+		return 0, fmt.Errorf("protocol version %d is no longer supported, the server speaks %d-%d", requested, minProtocolVersion, maxProtocolVersion)
+	}
+	return min(requested, maxProtocolVersion), nil
+}
+
+// rejectProtocol closes an upgraded connection with closeProtocolUnsupported
+func rejectProtocol(conn *websocket.Conn, reason error) {
+	rejectWebSocket(conn, closeProtocolUnsupported, reason.Error())
+}
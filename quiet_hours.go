@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuietHoursConfig is a do-not-disturb window. Start after End wraps past midnight.
+type QuietHoursConfig struct {
+	Enabled           bool   `json:"enabled"`
+	Start             string `json:"start"`             // "22:00"
+	End               string `json:"end"`               // "07:00"
+	MinBannerPriority string `json:"minBannerPriority"` // Banners below this priority are suppressed
+}
+
+// QuietHours holds the window and persists it to a JSON file
+type QuietHours struct {
+	config QuietHoursConfig
+	path   string
+	mutex  sync.RWMutex
+}
+
+var quietHours = newQuietHours("quiet_hours.json")
+
+func newQuietHours(path string) *QuietHours {
+	return &QuietHours{
+		config: QuietHoursConfig{Start: "22:00", End: "07:00", MinBannerPriority: "high"},
+		path:   path,
+	}
+}
+
+func (c QuietHoursConfig) validate() error {
+	if _, err := time.Parse("15:04", c.Start); err != nil {
+		return fmt.Errorf("start must be in HH:MM format")
+	}
+	if _, err := time.Parse("15:04", c.End); err != nil {
+		return fmt.Errorf("end must be in HH:MM format")
+	}
+	if c.Start == c.End {
+		return fmt.Errorf("start and end must differ")
+	}
+	if bannerPriorityRank(c.MinBannerPriority) < 0 {
+		return fmt.Errorf("minBannerPriority must be one of: %s", strings.Join(bannerPriorities, ", "))
+	}
+	return nil
+}
+
+func (qh *QuietHours) get() QuietHoursConfig {
+	qh.mutex.RLock()
+	defer qh.mutex.RUnlock()
+	return qh.config
+}
+
+func (qh *QuietHours) set(config QuietHoursConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	qh.mutex.Lock()
+	defer qh.mutex.Unlock()
+
+	qh.config = config
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(qh.path, data, 0644); err != nil {
+		log.Printf("Failed to persist quiet hours: %v", err)
+	}
+	return nil
+}
+
+func (qh *QuietHours) load() error {
+	data, err := os.ReadFile(qh.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	config := qh.get()
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	qh.mutex.Lock()
+	qh.config = config
+	qh.mutex.Unlock()
+	log.Printf("Loaded quiet hours %s-%s (enabled: %t) from %s", config.Start, config.End, config.Enabled, qh.path)
+	return nil
+}
+
+// active reports whether now falls inside the window, compared as times of day
+func (qh *QuietHours) active(now time.Time) bool {
+	config := qh.get()
+	if !config.Enabled {
+		return false
+	}
+
+	minute := now.Format("15:04")
+	if config.Start < config.End {
+		return minute >= config.Start && minute < config.End
+	}
+	return minute >= config.Start || minute < config.End
+}
+
+// suppressesBanner reports whether a banner of this priority is held back right now
+func (qh *QuietHours) suppressesBanner(priority string, now time.Time) bool {
+	return qh.active(now) && bannerPriorityRank(priority) < bannerPriorityRank(qh.get().MinBannerPriority)
+}
+
+func handleQuietHours(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// Start from the active config so omitted fields are left unchanged
+		config := quietHours.get()
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := quietHours.set(config); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Quiet hours set to %s-%s (enabled: %t) via HTTP", config.Start, config.End, config.Enabled)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	config := quietHours.get()
+	response := map[string]interface{}{
+		"enabled":           config.Enabled,
+		"start":             config.Start,
+		"end":               config.End,
+		"minBannerPriority": config.MinBannerPriority,
+		"active":            quietHours.active(time.Now().In(defaultLocation())),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
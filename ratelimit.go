@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+)
+
+// wsRateLimit is the sustained number of messages per second a client may send (WS_RATE_LIMIT)
+var wsRateLimit = envInt("WS_RATE_LIMIT", 50)
+
+// rateLimitAbuseFactor is how many times the limit a client may overshoot in one second before it is disconnected
+const rateLimitAbuseFactor = 4
+
+// RateLimiter is a token bucket for one client's inbound messages, only used from its readPump
+type RateLimiter struct {
+	rate          float64
+	tokens        float64
+	last          time.Time
+	windowStart   time.Time
+	windowDropped int
+}
+
+func newRateLimiter(perSecond int) *RateLimiter {
+	return &RateLimiter{
+		rate:   float64(perSecond),
+		tokens: float64(perSecond),
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token if one is available. throttled is set for the first drop of each one-second window
+// so callers can log without flooding, and abusive once the client overshoots by rateLimitAbuseFactor.
+func (rl *RateLimiter) allow(now time.Time) (ok, throttled, abusive bool) {
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+	rl.last = now
+
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return true, false, false
+	}
+
+	if now.Sub(rl.windowStart) >= time.Second {
+		rl.windowStart = now
+		rl.windowDropped = 0
+	}
+	rl.windowDropped++
+	return false, rl.windowDropped == 1, float64(rl.windowDropped) > rl.rate*rateLimitAbuseFactor
+}
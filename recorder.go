@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRollingMinutes caps the rolling buffer, which is held in memory at about 11MB per minute
+const maxRollingMinutes = 10
+
+// pcmByteRate is how many bytes of capture PCM make up one second
+const pcmByteRate = captureSampleRate * captureChannels * pcmBitsPerSample / 8
+
+// recordingsDir is where recordings are written (RECORDINGS_DIR)
+var recordingsDir = func() string {
+	if dir := os.Getenv("RECORDINGS_DIR"); dir != "" {
+		return dir
+	}
+	return "recordings"
+}()
+
+var (
+	errNotRecording     = errors.New("no recording in progress")
+	errAlreadyRecording = errors.New("already recording")
+)
+
+// RecordingStatus describes the recording in progress, or the last one once it has stopped
+type RecordingStatus struct {
+	Recording bool       `json:"recording"`
+	File      string     `json:"file,omitempty"`
+	Format    string     `json:"format,omitempty"`  // wav or raw
+	Minutes   int        `json:"minutes,omitempty"` // Rolling buffer length, 0 keeps everything
+	Started   *time.Time `json:"started,omitempty"`
+	Duration  float64    `json:"duration"` // Seconds of audio recorded, or held by the rolling buffer
+	Bytes     int64      `json:"bytes"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// recording is one capture session. Continuous recordings are written as the frames arrive,
+// rolling ones are kept in memory and only written out when they stop.
+type recording struct {
+	path    string
+	format  string
+	rolling int // Bytes the rolling buffer keeps, 0 when writing continuously
+	started time.Time
+
+	file    *os.File
+	frames  [][]byte     // Rolling buffer, oldest first
+	bytes   atomic.Int64 // PCM bytes written to the file or held in the buffer
+	err     error        // Why the recording ended early, set before done is closed
+	channel chan []byte
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// AudioRecorder runs at most one recording at a time
+type AudioRecorder struct {
+	active *recording
+	last   *RecordingStatus
+	mutex  sync.Mutex
+}
+
+var audioRecorder = &AudioRecorder{}
+
+func (rec *recording) status(active bool) RecordingStatus {
+	started := rec.started
+	status := RecordingStatus{
+		Recording: active,
+		File:      rec.path,
+		Format:    rec.format,
+		Minutes:   rec.rolling / (pcmByteRate * 60),
+		Started:   &started,
+		Bytes:     rec.bytes.Load(),
+	}
+	status.Duration = float64(status.Bytes) / pcmByteRate
+	// err belongs to the recording goroutine until it is done
+	if !active && rec.err != nil {
+		status.Error = rec.err.Error()
+	}
+	return status
+}
+
+// start subscribes a new recording to the multiplexer. minutes > 0 keeps only that many minutes.
+func (ar *AudioRecorder) start(format string, minutes int) (RecordingStatus, error) {
+	if format == "" {
+		format = "wav"
+	}
+	if format != "wav" && format != "raw" {
+		return RecordingStatus{}, fmt.Errorf("format must be wav or raw")
+	}
+	if minutes < 0 || minutes > maxRollingMinutes {
+		return RecordingStatus{}, fmt.Errorf("minutes must be between 0 and %d", maxRollingMinutes)
+	}
+
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	if ar.active != nil {
+		return RecordingStatus{}, fmt.Errorf("%w to %s", errAlreadyRecording, ar.active.path)
+	}
+
+	if err := os.MkdirAll(recordingsDir, 0755); err != nil {
+		return RecordingStatus{}, err
+	}
+
+	now := time.Now()
+	rec := &recording{
+		path:    filepath.Join(recordingsDir, "recording-"+now.Format("20060102-150405")+"."+format),
+		format:  format,
+		rolling: minutes * 60 * pcmByteRate,
+		started: now,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	// Continuous recordings stream to disk, a rolling one only creates its file when it stops
+	if rec.rolling == 0 {
+		file, err := rec.create()
+		if err != nil {
+			return RecordingStatus{}, err
+		}
+		rec.file = file
+	}
+
+	// Subscribe first so an idle shutdown can't race the capture start
+	rec.channel = audioMultiplexer.subscribe("recording")
+	if err := ensureAudioCapture(); err != nil {
+		audioMultiplexer.unsubscribe(rec.channel)
+		if rec.file != nil {
+			rec.file.Close()
+			os.Remove(rec.path)
+		}
+		return RecordingStatus{}, fmt.Errorf("audio capture unavailable: %v", err)
+	}
+
+	ar.active = rec
+	go ar.run(rec)
+	log.Printf("Recording %s audio to %s", format, rec.path)
+	return rec.status(true), nil
+}
+
+// create opens the file, with a placeholder WAV header that finish fills in
+func (rec *recording) create() (*os.File, error) {
+	file, err := os.Create(rec.path)
+	if err != nil {
+		return nil, err
+	}
+	if rec.format == "wav" {
+		if _, err := file.Write(wavStreamHeader()); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+// run feeds the recording until it is stopped or a write fails, then finalizes the file
+func (ar *AudioRecorder) run(rec *recording) {
+	defer close(rec.done)
+
+	for rec.err == nil {
+		select {
+		case <-rec.stop:
+			rec.err = rec.finish()
+			return
+		case frame := <-rec.channel:
+			rec.err = rec.write(frame)
+		}
+	}
+
+	log.Printf("Recording to %s failed: %v", rec.path, rec.err)
+	rec.finish()
+
+	ar.mutex.Lock()
+	if ar.active == rec {
+		ar.active = nil
+		status := rec.status(false)
+		ar.last = &status
+	}
+	ar.mutex.Unlock()
+}
+
+func (rec *recording) write(frame []byte) error {
+	if rec.rolling == 0 {
+		if rec.format == "wav" && rec.bytes.Load()+int64(len(frame)) > wavUnknownSize-36 {
+			return fmt.Errorf("WAV size limit reached")
+		}
+		if _, err := rec.file.Write(frame); err != nil {
+			return err
+		}
+		rec.bytes.Add(int64(len(frame)))
+		return nil
+	}
+
+	rec.frames = append(rec.frames, frame)
+	buffered := rec.bytes.Add(int64(len(frame)))
+	for buffered > int64(rec.rolling) && len(rec.frames) > 1 {
+		buffered = rec.bytes.Add(-int64(len(rec.frames[0])))
+		rec.frames[0] = nil
+		rec.frames = rec.frames[1:]
+	}
+	return nil
+}
+
+// finish unsubscribes, writes out the rolling buffer and fixes up the WAV sizes
+func (rec *recording) finish() error {
+	audioMultiplexer.unsubscribe(rec.channel)
+
+	if rec.file == nil {
+		file, err := rec.create()
+		if err != nil {
+			return err
+		}
+		rec.file = file
+		for _, frame := range rec.frames {
+			if _, err := file.Write(frame); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		rec.frames = nil
+	}
+
+	if rec.format == "wav" {
+		if _, err := rec.file.WriteAt(wavHeader(uint32(rec.bytes.Load())), 0); err != nil {
+			rec.file.Close()
+			return err
+		}
+	}
+	return rec.file.Close()
+}
+
+// stop ends the recording in progress and returns its final status once the file is closed
+func (ar *AudioRecorder) stop() (RecordingStatus, error) {
+	ar.mutex.Lock()
+	rec := ar.active
+	ar.active = nil
+	ar.mutex.Unlock()
+
+	if rec == nil {
+		return RecordingStatus{}, errNotRecording
+	}
+
+	close(rec.stop)
+	<-rec.done
+	status := rec.status(false)
+	log.Printf("Recording stopped, %.0fs saved to %s", status.Duration, rec.path)
+
+	ar.mutex.Lock()
+	ar.last = &status
+	ar.mutex.Unlock()
+	return status, rec.err
+}
+
+func (ar *AudioRecorder) status() RecordingStatus {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+
+	if ar.active != nil {
+		return ar.active.status(true)
+	}
+	if ar.last != nil {
+		return *ar.last
+	}
+	return RecordingStatus{}
+}
+
+func handleRecordingStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(audioRecorder.status())
+}
+
+func handleRecordingStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Format  string `json:"format"`
+		Minutes int    `json:"minutes"`
+	}
+	// An empty body records WAV until stopped
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	status, err := audioRecorder.start(req.Format, req.Minutes)
+	if errors.Is(err, errAlreadyRecording) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func handleRecordingStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	status, err := audioRecorder.stop()
+	if errors.Is(err, errNotRecording) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save recording: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
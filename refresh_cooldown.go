@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RefreshCooldownState is the default minimum time between refreshes of one client
+type RefreshCooldownState struct {
+	value time.Duration
+	mutex sync.RWMutex
+}
+
+var refreshCooldownState = &RefreshCooldownState{value: defaultRefreshCooldown()}
+
+// RefreshCooldownMessage overrides the cooldown for the sending client, a null cooldown restores the default
+type RefreshCooldownMessage struct {
+	Type     string `json:"type"`
+	Cooldown *int   `json:"cooldown"` // Seconds
+}
+
+// defaultRefreshCooldown reads REFRESH_COOLDOWN in seconds, 0 disables the cooldown
+func defaultRefreshCooldown() time.Duration {
+	value := os.Getenv("REFRESH_COOLDOWN")
+	if value == "" {
+		return 2 * time.Minute
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		log.Printf("Invalid REFRESH_COOLDOWN %q, using 120 seconds", value)
+		return 2 * time.Minute
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (rc *RefreshCooldownState) get() time.Duration {
+	rc.mutex.RLock()
+	defer rc.mutex.RUnlock()
+	return rc.value
+}
+
+func (rc *RefreshCooldownState) set(cooldown time.Duration) {
+	rc.mutex.Lock()
+	rc.value = cooldown
+	rc.mutex.Unlock()
+}
+
+// effectiveRefreshCooldown is the client's override if it set one, otherwise the global default
+func (c *Client) effectiveRefreshCooldown() time.Duration {
+	c.mutex.RLock()
+	override := c.refreshCooldown
+	c.mutex.RUnlock()
+
+	if override != nil {
+		return *override
+	}
+	return refreshCooldownState.get()
+}
+
+func handleRefreshCooldownMessage(client *Client, msg *RefreshCooldownMessage) {
+	if msg.Cooldown != nil && *msg.Cooldown < 0 {
+		sendError(client, "Refresh cooldown must be zero or a positive number of seconds")
+		return
+	}
+
+	var override *time.Duration
+	if msg.Cooldown != nil {
+		cooldown := time.Duration(*msg.Cooldown) * time.Second
+		override = &cooldown
+	}
+
+	client.mutex.Lock()
+	client.refreshCooldown = override
+	client.mutex.Unlock()
+	log.Printf("Refresh cooldown for client %s set to %s", client.id, client.effectiveRefreshCooldown())
+}
+
+func handleRefreshCooldown(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Cooldown int `json:"cooldown"` // Seconds
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if req.Cooldown < 0 {
+			writeJSONError(w, http.StatusBadRequest, "Cooldown must be zero or a positive number of seconds")
+			return
+		}
+
+		refreshCooldownState.set(time.Duration(req.Cooldown) * time.Second)
+		log.Printf("Refresh cooldown set to %ds via HTTP", req.Cooldown)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string]int{"cooldown": int(refreshCooldownState.get() / time.Second)}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
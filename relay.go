@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// relayedMessageTypes are the client message types forwarded to every other client, from the
+// comma-separated WS_RELAY_TYPES. Anything the server doesn't handle or relay is answered with an error.
+var relayedMessageTypes = parseRelayTypes(os.Getenv("WS_RELAY_TYPES"))
+
+func parseRelayTypes(value string) map[string]bool {
+	types := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			types[name] = true
+		}
+	}
+	return types
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// resumeTokenTTL is how long a disconnected client's state is kept for a reconnect
+const resumeTokenTTL = 3 * time.Minute
+
+// SessionMessage tells a client its ID and the token to present when it reconnects
+type SessionMessage struct {
+	Type        string `json:"type"`
+	ClientID    string `json:"clientId"`
+	ResumeToken string `json:"resumeToken"`
+	Resumed     bool   `json:"resumed"`
+	Protocol    int    `json:"protocol"` // Negotiated WebSocket protocol version
+}
+
+// ResumeState is the per-client state carried over a reconnect
+type ResumeState struct {
+	id              string
+	connectedAt     time.Time
+	tab             string
+	location        *time.Location
+	muted           bool
+	lastRefresh     time.Time
+	refreshCooldown *time.Duration
+	autoRefreshes   int
+	lastAutoRefresh time.Time
+	expiresAt       time.Time
+}
+
+// ResumeStore keeps the state of recently disconnected clients keyed by resume token
+type ResumeStore struct {
+	sessions map[string]*ResumeState
+	mutex    sync.Mutex
+}
+
+var resumeStore = &ResumeStore{sessions: make(map[string]*ResumeState)}
+
+func newResumeToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("Failed to generate resume token: %v", err)
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// save stores a disconnecting client's state under its token
+func (rs *ResumeStore) save(client *Client) {
+	if client.resumeToken == "" {
+		return
+	}
+
+	client.mutex.RLock()
+	state := &ResumeState{
+		id:              client.id,
+		connectedAt:     client.connectedAt,
+		tab:             client.tab,
+		location:        client.location,
+		muted:           client.muted,
+		lastRefresh:     client.lastRefresh,
+		refreshCooldown: client.refreshCooldown,
+		autoRefreshes:   client.autoRefreshes,
+		lastAutoRefresh: client.lastAutoRefresh,
+		expiresAt:       time.Now().Add(resumeTokenTTL),
+	}
+	client.mutex.RUnlock()
+
+	rs.mutex.Lock()
+	rs.sessions[client.resumeToken] = state
+	rs.expireLocked(time.Now())
+	rs.mutex.Unlock()
+}
+
+// take returns and forgets the state for a token, nil if unknown or expired
+func (rs *ResumeStore) take(token string) *ResumeState {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.expireLocked(time.Now())
+	state, ok := rs.sessions[token]
+	if !ok {
+		return nil
+	}
+	delete(rs.sessions, token)
+	return state
+}
+
+// expireLocked drops sessions past their TTL, the caller must hold the mutex
+func (rs *ResumeStore) expireLocked(now time.Time) {
+	for token, state := range rs.sessions {
+		if now.After(state.expiresAt) {
+			delete(rs.sessions, token)
+		}
+	}
+}
+
+// restore applies a resumed state to a freshly created client
+func (state *ResumeState) restore(client *Client) {
+	client.id = state.id
+	client.connectedAt = state.connectedAt
+	client.tab = state.tab
+	client.location = state.location
+	client.muted = state.muted
+	client.lastRefresh = state.lastRefresh
+	client.refreshCooldown = state.refreshCooldown
+	client.autoRefreshes = state.autoRefreshes
+	client.lastAutoRefresh = state.lastAutoRefresh
+}
+
+func sendSession(client *Client, resumed bool) {
+	data, err := json.Marshal(SessionMessage{
+		Type:        "session",
+		ClientID:    client.id,
+		ResumeToken: client.resumeToken,
+		Resumed:     resumed,
+		Protocol:    client.protocol,
+	})
+	if err != nil {
+		log.Println("Error marshaling session message:", err)
+		return
+	}
+	sendPriority(client, data)
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	opus "gopkg.in/hraban/opus.v2"
+)
+
+// Consecutive failures of one encoder before the tracks it feeds are dropped and their clients
+// told with an audio-error
+const (
+	encoderCreateAttempts = 3
+	maxEncodeErrors       = 50 // One second of 20ms frames
+)
+
+// AudioErrorMessage tells a client its stream broke and won't recover without a new offer
+type AudioErrorMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// sharedEncoderIdleCheck is how often the encoder checks for an empty subscriber list while no
+// frames are arriving
+const sharedEncoderIdleCheck = time.Second
+
+// audioTabOnly pauses a client's stream while it isn't showing the audio tab, saving encoding
+// and bandwidth for displays that only show the clock. AUDIO_TAB_ONLY=false streams to every tab.
+var audioTabOnly = os.Getenv("AUDIO_TAB_ONLY") != "false"
+
+// trackSubscriber is one client's WebRTC track fed by the shared encoder
+type trackSubscriber struct {
+	client  *Client
+	track   *webrtc.TrackLocalStaticSample
+	codec   string          // The track's MIME type
+	bitrate *BitrateAdapter // The client's Opus bitrate from RTCP feedback, nil without one
+	removed chan struct{}   // Closed once the subscriber is dropped
+	paused  bool            // Off the audio tab, only touched by the encoding goroutine
+	leaving atomic.Bool     // Waiting for its fade-out frame before removal, see leave
+
+	subscribedAt time.Time
+	stats        streamCounters // Served on /api/audio/stats
+}
+
+// SharedEncoder encodes each PCM frame once per negotiated codec and bitrate step and writes the
+// packet to every subscribed track, instead of every client running its own encoder over
+// identical audio
+type SharedEncoder struct {
+	subscribers map[*Client]*trackSubscriber
+	running     bool // The encoding goroutine is alive
+	mutex       sync.Mutex
+}
+
+var sharedEncoder = &SharedEncoder{subscribers: make(map[*Client]*trackSubscriber)}
+
+// add subscribes a track, starting the encoding goroutine for the first one
+func (se *SharedEncoder) add(client *Client, track *webrtc.TrackLocalStaticSample) *trackSubscriber {
+	client.mutex.RLock()
+	bitrate := client.bitrate
+	client.mutex.RUnlock()
+
+	subscriber := &trackSubscriber{
+		client:  client,
+		track:   track,
+		codec:   track.Codec().MimeType,
+		bitrate: bitrate,
+		removed: make(chan struct{}),
+
+		subscribedAt: time.Now(),
+	}
+
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	if previous := se.subscribers[client]; previous != nil {
+		close(previous.removed)
+	}
+	se.subscribers[client] = subscriber
+	log.Printf("%s track subscribed to shared encoder (%d active)", subscriber.codec, len(se.subscribers))
+	notifyAudioListeners()
+
+	if !se.running {
+		se.running = true
+		go se.run()
+	}
+	return subscriber
+}
+
+// remove drops a subscriber, it is a no-op if the subscriber is already gone
+func (se *SharedEncoder) remove(subscriber *trackSubscriber) {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	if se.subscribers[subscriber.client] != subscriber {
+		return
+	}
+	delete(se.subscribers, subscriber.client)
+	close(subscriber.removed)
+	log.Printf("Track unsubscribed from shared encoder (%d active)", len(se.subscribers))
+	notifyAudioListeners()
+}
+
+func (se *SharedEncoder) snapshot() []*trackSubscriber {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	subscribers := make([]*trackSubscriber, 0, len(se.subscribers))
+	for _, subscriber := range se.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	return subscribers
+}
+
+// stopIfIdle marks the encoder stopped when nobody is subscribed. It is checked under the same
+// lock add takes, so a track added concurrently either is seen here or starts a new goroutine.
+func (se *SharedEncoder) stopIfIdle() bool {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	if len(se.subscribers) > 0 {
+		return false
+	}
+	se.running = false
+	return true
+}
+
+// stop drops every subscriber, used when the capture can't be started
+func (se *SharedEncoder) stop() {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	for client, subscriber := range se.subscribers {
+		delete(se.subscribers, client)
+		close(subscriber.removed)
+	}
+	se.running = false
+	notifyAudioListeners()
+}
+
+// opusApplications maps the application setting to the Opus mode. voip favours speech
+// intelligibility, lowdelay drops the speech tools for the lowest algorithmic delay.
+var opusApplications = map[string]opus.Application{
+	"voip":     opus.AppVoIP,
+	"audio":    opus.AppAudio,
+	"lowdelay": opus.AppRestrictedLowdelay,
+}
+
+func newOpusEncoder(settings AudioSettings, channels int) (*opus.Encoder, error) {
+	enc, err := opus.NewEncoder(captureSampleRate, channels, opusApplications[settings.Application])
+	if err != nil {
+		return nil, err
+	}
+
+	// Set low latency and high quality
+	enc.SetBitrate(settings.Bitrate)
+	enc.SetComplexity(settings.Complexity)
+	return enc, nil
+}
+
+func audioChannels(settings AudioSettings) int {
+	if settings.Mono {
+		return 1
+	}
+	return 2
+}
+
+// What a frame did to the stream's silence pause
+const (
+	silenceUnchanged = iota
+	silencePaused
+	silenceResumed
+)
+
+// silencePause pauses the shared stream after SilenceFrames consecutive silent frames and resumes
+// it on the first frame with sound
+type silencePause struct {
+	silentFrames int
+	paused       bool
+}
+
+func (sp *silencePause) observe(silent bool, settings AudioSettings) int {
+	if !silent {
+		sp.silentFrames = 0
+		if sp.paused {
+			sp.paused = false
+			return silenceResumed
+		}
+		return silenceUnchanged
+	}
+
+	sp.silentFrames++
+	if sp.silentFrames >= settings.SilenceFrames && !sp.paused {
+		sp.paused = true
+		return silencePaused
+	}
+	return silenceUnchanged
+}
+
+func (se *SharedEncoder) run() {
+	// Subscribe to the audio multiplexer first so an idle shutdown can't race the capture start
+	audioChannel := audioMultiplexer.subscribe("webrtc")
+	defer audioMultiplexer.unsubscribe(audioChannel)
+
+	// Ensure the shared audio capture process is running
+	if err := ensureAudioCapture(); err != nil {
+		log.Printf("Failed to start audio capture: %v", err)
+		se.stop()
+		return
+	}
+	audioPlaying.set(true)
+	defer audioPlaying.set(false)
+
+	settings := audioConfig.get()
+	channels := audioChannels(settings)
+
+	// One encoder per codec and bitrate in use, created when a track first needs a packet from it.
+	// Muted clients get silence so their track keeps flowing and unmute is instant. The silence
+	// has its own encoders so it doesn't disturb the main encoders' state.
+	encoders := make(map[encoderKey]AudioEncoder)
+	silenceEncoders := make(map[encoderKey]AudioEncoder)
+	failures := make(map[encoderKey]int)
+	silenceFailures := make(map[encoderKey]int)
+	fadeFailures := make(map[encoderKey]int)
+
+	// PCM frame size at the default 20ms: 960 samples * 2 channels * 2 bytes = 3840 bytes.
+	// The capture is always stereo, mono mode averages each pair down to 960 samples.
+	// Frames are measured as they arrive so a capture restarted with a new duration keeps working.
+	var pcmBuffer, silenceBuffer, fadeBuffer []int16 // int16 samples
+	packet := make([]byte, 4000)                     // Encoder output buffer
+
+	log.Printf("Starting shared audio encoding (48kHz %d channel(s) @ %s frames)", channels, settings.frameDuration())
+
+	idleCheck := time.NewTicker(sharedEncoderIdleCheck)
+	defer idleCheck.Stop()
+
+	sampleCount := 0
+	startTime := time.Now()
+	var pause silencePause
+
+	for {
+		var rawBuffer []byte
+		select {
+		case <-idleCheck.C:
+			if se.stopIfIdle() {
+				log.Println("No tracks left, stopping shared encoder")
+				return
+			}
+			continue
+		case rawBuffer = <-audioChannel:
+		}
+
+		// Pick up config changes made while streaming
+		if current := audioConfig.get(); current != settings {
+			if audioChannels(current) != channels || current.Application != settings.Application {
+				// Opus decoders follow a channel or mode change between packets, so the switch is
+				// live. The encoders are recreated with the new settings on the next frame.
+				encoders = make(map[encoderKey]AudioEncoder)
+				silenceEncoders = make(map[encoderKey]AudioEncoder)
+				failures = make(map[encoderKey]int)
+				silenceFailures = make(map[encoderKey]int)
+				fadeFailures = make(map[encoderKey]int)
+				channels = audioChannels(current)
+				log.Printf("Encoder switched to %d channel(s), %s mode", channels, current.Application)
+			}
+			// A new bitrate moves tracks to other encoders, complexity applies to the existing ones
+			if current.Complexity != settings.Complexity {
+				for _, all := range []map[encoderKey]AudioEncoder{encoders, silenceEncoders} {
+					for _, enc := range all {
+						if enc, ok := enc.(*opus.Encoder); ok {
+							enc.SetComplexity(current.Complexity)
+						}
+					}
+				}
+			}
+			settings = current
+			log.Printf("Encoder updated to %d bps, complexity %d", settings.Bitrate, settings.Complexity)
+		}
+
+		samplesPerChannel := len(rawBuffer) / captureFrameBytes
+		frameDuration := time.Duration(samplesPerChannel) * time.Second / captureSampleRate
+		if len(pcmBuffer) != samplesPerChannel*channels {
+			pcmBuffer = make([]int16, samplesPerChannel*channels)
+			silenceBuffer = make([]int16, samplesPerChannel*channels)
+			fadeBuffer = make([]int16, samplesPerChannel*channels)
+		}
+
+		volumeState.mutex.RLock()
+		gain := volumeState.gain
+		volumeState.mutex.RUnlock()
+		leftBalance, rightBalance := balanceGains(currentBalance())
+
+		// Convert bytes to int16 samples and check for silence (threshold 0 disables pausing)
+		silenceThreshold := int16(settings.SilenceThreshold)
+		isSilent := silenceThreshold > 0
+		for i := 0; i < samplesPerChannel; i++ {
+			left := int16(rawBuffer[i*4]) | int16(rawBuffer[i*4+1])<<8
+			right := int16(rawBuffer[i*4+2]) | int16(rawBuffer[i*4+3])<<8
+
+			// Check if samples exceed silence threshold (before volume, so quiet playback isn't paused)
+			if left > silenceThreshold || left < -silenceThreshold || right > silenceThreshold || right < -silenceThreshold {
+				isSilent = false
+			}
+
+			// Balance only applies to stereo, a downmix has no sides to favour
+			if channels == 1 {
+				pcmBuffer[i] = applyGain(int16((int32(left)+int32(right))/2), gain)
+			} else {
+				pcmBuffer[i*2] = applyGain(left, gain*leftBalance)
+				pcmBuffer[i*2+1] = applyGain(right, gain*rightBalance)
+			}
+		}
+
+		switch pause.observe(isSilent, settings) {
+		case silencePaused:
+			log.Printf("Silence detected for %s, pausing stream", time.Duration(settings.SilenceFrames)*frameDuration)
+			// With the gate on, the playing state follows the gate instead, see silenceGate.filter
+			if !silenceGateEnabled {
+				audioPlaying.set(false)
+			}
+			for _, subscriber := range se.snapshot() {
+				subscriber.stats.silencePauses.Add(1)
+			}
+		case silenceResumed:
+			log.Println("Audio detected, resuming stream")
+			if !silenceGateEnabled {
+				audioPlaying.set(true)
+			}
+			if audioFadeEnabled {
+				applyRamp(pcmBuffer, pcmBuffer, channels, 0, 1)
+			}
+		}
+
+		// Only encode and send if streaming is active
+		if pause.paused {
+			continue
+		}
+
+		// Sort subscribers by encoder into those getting audio and those getting silence, skipping
+		// paused ones. With AUDIO_FADE, tracks starting or stopping get a ramped frame instead.
+		listening := make(map[encoderKey][]*trackSubscriber)
+		muted := make(map[encoderKey][]*trackSubscriber)
+		fadingOut := make(map[encoderKey][]*trackSubscriber)
+		fadingIn := make(map[encoderKey][]*trackSubscriber)
+		var leaving []*trackSubscriber
+		for _, subscriber := range se.snapshot() {
+			// Disabled clients are dropped entirely, unlike mute
+			if !audioPreferences.enabled(subscriber.client) && !subscriber.leaving.Load() {
+				log.Printf("Audio disabled for client %s, stopping stream", subscriber.client.id)
+				if !audioFadeEnabled {
+					se.remove(subscriber)
+					continue
+				}
+				subscriber.leaving.Store(true)
+			}
+
+			subscriber.client.mutex.RLock()
+			isMuted := subscriber.client.muted
+			onAudioTab := subscriber.client.tab == "audio"
+			subscriber.client.mutex.RUnlock()
+			fadeKey := encoderKey{codec: subscriber.codec}
+
+			// Paused and muted tracks are already silent, they have nothing to fade
+			if subscriber.leaving.Load() {
+				if subscriber.paused || isMuted {
+					se.remove(subscriber)
+					continue
+				}
+				fadingOut[fadeKey] = append(fadingOut[fadeKey], subscriber)
+				leaving = append(leaving, subscriber)
+				continue
+			}
+
+			if paused := audioTabOnly && !onAudioTab; paused != subscriber.paused {
+				subscriber.paused = paused
+				if paused {
+					subscriber.stats.tabPauses.Add(1)
+					log.Printf("Client %s left the audio tab, pausing its stream", subscriber.client.id)
+					if audioFadeEnabled && !isMuted {
+						fadingOut[fadeKey] = append(fadingOut[fadeKey], subscriber)
+					}
+				} else {
+					log.Printf("Client %s is on the audio tab, resuming its stream", subscriber.client.id)
+					if audioFadeEnabled && !isMuted {
+						fadingIn[fadeKey] = append(fadingIn[fadeKey], subscriber)
+						continue
+					}
+				}
+			}
+			if subscriber.paused {
+				continue
+			}
+
+			if isMuted {
+				// Silence costs next to nothing at any bitrate, one encoder per codec does
+				key := encoderKey{codec: subscriber.codec}
+				muted[key] = append(muted[key], subscriber)
+			} else {
+				key := subscriber.encoderKey(settings)
+				listening[key] = append(listening[key], subscriber)
+			}
+		}
+
+		// Drop encoders no track uses anymore, such as a bitrate step every client has left
+		pruneEncoders(encoders, listening)
+		pruneEncoders(silenceEncoders, muted)
+
+		tracks := 0
+		for key, subscribers := range listening {
+			if se.encodeAndWrite(encoders, failures, key, settings, channels, pcmBuffer, packet, subscribers, frameDuration) {
+				tracks += len(subscribers)
+			}
+		}
+		for key, subscribers := range muted {
+			se.encodeAndWrite(silenceEncoders, silenceFailures, key, settings, channels, silenceBuffer, packet, subscribers, frameDuration)
+		}
+		se.fade(fadingOut, fadeFailures, settings, channels, fadeBuffer, pcmBuffer, 1, 0, packet, frameDuration)
+		se.fade(fadingIn, fadeFailures, settings, channels, fadeBuffer, pcmBuffer, 0, 1, packet, frameDuration)
+		for _, subscriber := range leaving {
+			se.remove(subscriber)
+		}
+
+		if tracks > 0 {
+			sampleCount++
+			if sampleCount%50 == 0 {
+				elapsed := time.Since(startTime).Seconds()
+				packetsPerSec := float64(sampleCount) / elapsed
+				log.Printf("Streamed %d frames to %d track(s) (%.1f frames/s)", sampleCount, tracks, packetsPerSec)
+			}
+		}
+	}
+}
+
+// encoderKey identifies one of the shared encoders. Bitrate is 0 for codecs without one and for
+// silence, which use the configured settings.
+type encoderKey struct {
+	codec   string
+	bitrate int
+}
+
+// encoderKey picks the encoder for a listening subscriber, at its adapted bitrate for Opus
+func (ts *trackSubscriber) encoderKey(settings AudioSettings) encoderKey {
+	if ts.codec != webrtc.MimeTypeOpus {
+		return encoderKey{codec: ts.codec}
+	}
+	return encoderKey{codec: ts.codec, bitrate: ts.bitrate.target(settings.Bitrate)}
+}
+
+func pruneEncoders(encoders map[encoderKey]AudioEncoder, inUse map[encoderKey][]*trackSubscriber) {
+	for key := range encoders {
+		if _, ok := inUse[key]; !ok {
+			delete(encoders, key)
+		}
+	}
+}
+
+// encodeAndWrite encodes a frame with the key's encoder from encoders, creating it on first use,
+// and writes the packet to the subscribers. It reports whether the packet was sent. Creation is
+// retried on the next frames and failures counts consecutive errors per key, once they pass the
+// limits the subscribers are given up on.
+func (se *SharedEncoder) encodeAndWrite(encoders map[encoderKey]AudioEncoder, failures map[encoderKey]int, key encoderKey, settings AudioSettings, channels int,
+	pcm []int16, packet []byte, subscribers []*trackSubscriber, duration time.Duration) bool {
+	enc := encoders[key]
+	if enc == nil {
+		if key.bitrate > 0 {
+			settings.Bitrate = key.bitrate
+		}
+		var err error
+		if enc, err = newAudioEncoder(key.codec, settings, channels); err != nil {
+			failures[key]++
+			log.Printf("Failed to create %s encoder (attempt %d/%d): %v", key.codec, failures[key], encoderCreateAttempts, err)
+			if failures[key] >= encoderCreateAttempts {
+				delete(failures, key)
+				se.fail(subscribers, fmt.Sprintf("The %s encoder could not be created: %v", key.codec, err))
+			}
+			return false
+		}
+		encoders[key] = enc
+		delete(failures, key)
+	}
+
+	packetLen, err := enc.Encode(pcm, packet)
+	if err != nil {
+		// Logged once per streak, a broken encoder fails every frame
+		if failures[key]++; failures[key] == 1 {
+			log.Printf("%s encoding error: %v", key.codec, err)
+		}
+		if failures[key] >= maxEncodeErrors {
+			delete(failures, key)
+			delete(encoders, key)
+			se.fail(subscribers, fmt.Sprintf("%s encoding failed %d times in a row: %v", key.codec, maxEncodeErrors, err))
+		}
+		return false
+	}
+	delete(failures, key)
+	opusPacketsEncoded.Add(1)
+	se.write(subscribers, packet[:packetLen], duration)
+	return true
+}
+
+// fail drops subscribers whose encoder is broken, telling each client why so the UI can show it
+func (se *SharedEncoder) fail(subscribers []*trackSubscriber, reason string) {
+	data, err := json.Marshal(AudioErrorMessage{
+		Type:    "audio-error",
+		Message: reason,
+	})
+	if err != nil {
+		log.Println("Error marshaling audio-error message:", err)
+	}
+
+	for _, subscriber := range subscribers {
+		log.Printf("Audio stream for client %s is broken: %s", subscriber.client.id, reason)
+		if data != nil {
+			sendPriority(subscriber.client, data)
+		}
+		se.remove(subscriber)
+	}
+}
+
+// write sends one packet to each subscriber, dropping those whose track fails. WriteSample
+// copies the payload into RTP packets, so the same buffer can be reused for every track.
+func (se *SharedEncoder) write(subscribers []*trackSubscriber, packet []byte, duration time.Duration) {
+	for _, subscriber := range subscribers {
+		if err := subscriber.track.WriteSample(media.Sample{
+			Data:     packet,
+			Duration: duration,
+		}); err != nil {
+			log.Printf("Failed to write sample: %v", err)
+			se.remove(subscriber)
+			continue
+		}
+		subscriber.recordPacket(len(packet), duration)
+		opusPacketsSent.Add(1)
+	}
+}
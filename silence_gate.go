@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// silenceGateEnabled stops the multiplexer fanning out frames during extended silence, sparing
+// every gated listener the work of receiving and scanning them (AUDIO_SILENCE_GATE=true). It
+// uses the silenceThreshold and silenceFrames audio settings.
+var silenceGateEnabled = os.Getenv("AUDIO_SILENCE_GATE") == "true"
+
+// silenceGatePreroll is how many of the last held frames are sent ahead of the sound that reopens
+// the gate, so a soft onset isn't clipped
+const silenceGatePreroll = 3
+
+// continuousAudioListeners get every frame regardless of the gate. WAV and MP3 players expect an
+// unbroken stream and stall or drift when it has gaps, and recordings keep real time.
+var continuousAudioListeners = map[string]bool{"http": true, "mp3": true, "recording": true}
+
+// silenceGate tracks silence for the multiplexer, which runs it from its single goroutine
+type silenceGate struct {
+	silentFrames int
+	closed       bool
+	preroll      [][]byte // Most recent held frames, at most silenceGatePreroll
+}
+
+// pcmSilent reports whether no s16le sample in the frame exceeds threshold
+func pcmSilent(frame []byte, threshold int) bool {
+	for i := 0; i+1 < len(frame); i += 2 {
+		sample := int(int16(frame[i]) | int16(frame[i+1])<<8)
+		if sample > threshold || sample < -threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the frames gated listeners get for this one: the frame itself while the gate is
+// open, nothing while it is closed, and the pre-roll followed by the frame when sound reopens it.
+// While gating it also decides whether the shared audio is playing, unlike the encoder it sees the
+// stream even when no WebRTC client is listening.
+func (sg *silenceGate) filter(frame []byte, settings AudioSettings) [][]byte {
+	gating := silenceGateEnabled && settings.SilenceThreshold > 0
+	if !gating || !pcmSilent(frame, settings.SilenceThreshold) {
+		if gating {
+			audioPlaying.set(true)
+		}
+		frames := append(sg.preroll, frame)
+		if sg.closed {
+			log.Printf("Audio after %d silent frames, multiplexer resuming with %d pre-roll frame(s)", sg.silentFrames, len(sg.preroll))
+		}
+		sg.closed = false
+		sg.silentFrames = 0
+		sg.preroll = nil
+		return frames
+	}
+
+	sg.silentFrames++
+	if !sg.closed {
+		// The encoder pauses on its SilenceFrames-th silent frame, so it has to see all of them
+		if sg.silentFrames <= settings.SilenceFrames {
+			return [][]byte{frame}
+		}
+		log.Printf("Silence for %d frames, multiplexer holding frames back", sg.silentFrames)
+		sg.closed = true
+		audioPlaying.set(false)
+	}
+
+	silenceFramesGated.Add(1)
+	if len(sg.preroll) == silenceGatePreroll {
+		copy(sg.preroll, sg.preroll[1:])
+		sg.preroll = sg.preroll[:silenceGatePreroll-1]
+	}
+	sg.preroll = append(sg.preroll, frame)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// pcmFrame returns a stereo s16le frame with every sample at amplitude
+func pcmFrame(amplitude int16) []byte {
+	return bytes.Repeat([]byte{byte(amplitude), byte(amplitude >> 8)}, 8)
+}
+
+// useSilenceGate turns the gate on for the duration of a test
+func useSilenceGate(t *testing.T) {
+	previous := silenceGateEnabled
+	silenceGateEnabled = true
+	t.Cleanup(func() { silenceGateEnabled = previous })
+}
+
+func TestSilenceGateLetsEncoderPause(t *testing.T) {
+	useSilenceGate(t)
+	settings := AudioSettings{SilenceThreshold: 100, SilenceFrames: 5}
+
+	var gate silenceGate
+	var pause silencePause
+	var pauses, resumes, gatedOut int
+	feed := func(frame []byte) {
+		frames := gate.filter(frame, settings)
+		if len(frames) == 0 {
+			gatedOut++
+		}
+		// What the encoder gets is what the gate lets through
+		for _, frame := range frames {
+			switch pause.observe(pcmSilent(frame, settings.SilenceThreshold), settings) {
+			case silencePaused:
+				pauses++
+			case silenceResumed:
+				resumes++
+			}
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		feed(pcmFrame(1000))
+	}
+	for i := 0; i < 20; i++ {
+		feed(pcmFrame(0))
+	}
+	if pauses != 1 || !pause.paused {
+		t.Fatalf("encoder paused %d time(s) behind the gate, want 1", pauses)
+	}
+	if want := 20 - settings.SilenceFrames; gatedOut != want {
+		t.Fatalf("gate held back %d frames, want %d", gatedOut, want)
+	}
+
+	feed(pcmFrame(1000))
+	if resumes != 1 || pause.paused {
+		t.Fatalf("encoder resumed %d time(s) when sound came back, want 1", resumes)
+	}
+}
+
+func TestSilenceGateDrivesAudioPlaying(t *testing.T) {
+	useSilenceGate(t)
+	previousState, previousDebounce := audioPlaying, audioPlayingDebounce
+	audioPlaying, audioPlayingDebounce = &AudioPlayingState{}, 10*time.Millisecond
+	t.Cleanup(func() { audioPlaying, audioPlayingDebounce = previousState, previousDebounce })
+	settings := AudioSettings{SilenceThreshold: 100, SilenceFrames: 5}
+
+	waitForPlaying := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for audioPlaying.get() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("audio playing is %t, want %t", !want, want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	var gate silenceGate
+	gate.filter(pcmFrame(1000), settings)
+	waitForPlaying(true)
+
+	for i := 0; i <= settings.SilenceFrames; i++ {
+		gate.filter(pcmFrame(0), settings)
+	}
+	if !gate.closed {
+		t.Fatal("gate still open after the silence window")
+	}
+	waitForPlaying(false)
+
+	gate.filter(pcmFrame(1000), settings)
+	waitForPlaying(true)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapStatusInterval is how often the snapclient process is checked for start/stop
+const snapStatusInterval = 5 * time.Second
+
+// snapVerifyTimeout is how long a control action may take to show up in pgrep
+const snapVerifyTimeout = 5 * time.Second
+
+// snapDialTimeout bounds the reachability check before a new server is applied
+const snapDialTimeout = 3 * time.Second
+
+// SnapServerConfig is the Snapcast server snapclient connects to
+type SnapServerConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// SnapController starts and stops snapclient, either through systemd or as a child process
+type SnapController struct {
+	mode    string // "exec" runs snapclient directly, "systemctl" manages a service
+	service string // systemd unit name in systemctl mode
+	host    string
+	port    string
+	cmd     *exec.Cmd // Child process started in exec mode
+	path    string    // JSON file the server config is saved to
+	mutex   sync.Mutex
+}
+
+var snapController = newSnapController()
+
+func newSnapController() *SnapController {
+	sc := &SnapController{
+		mode:    os.Getenv("SNAP_CONTROL"),
+		service: os.Getenv("SNAP_SERVICE"),
+		host:    os.Getenv("SNAPSERVER_HOST"),
+		port:    os.Getenv("SNAPSERVER_PORT"),
+		path:    os.Getenv("SNAP_CONFIG_FILE"),
+	}
+	if sc.mode == "" {
+		sc.mode = "exec"
+	}
+	if sc.service == "" {
+		sc.service = "snapclient"
+	}
+	if sc.host == "" {
+		sc.host = "snapserver"
+	}
+	if sc.port == "" {
+		sc.port = "1704"
+	}
+	if sc.path == "" {
+		sc.path = "snapserver.json"
+	}
+	return sc
+}
+
+func (sc *SnapController) config() SnapServerConfig {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	port, _ := strconv.Atoi(sc.port)
+	return SnapServerConfig{Host: sc.host, Port: port}
+}
+
+// load applies a saved server config, which takes precedence over SNAPSERVER_HOST/PORT. It
+// reports whether one was found so the caller can restart snapclient onto it.
+func (sc *SnapController) load() (bool, error) {
+	data, err := os.ReadFile(sc.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var config SnapServerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return false, err
+	}
+
+	sc.mutex.Lock()
+	sc.host = config.Host
+	sc.port = strconv.Itoa(config.Port)
+	sc.mutex.Unlock()
+	log.Printf("Loaded Snapcast server %s:%d from %s", config.Host, config.Port, sc.path)
+	return true, nil
+}
+
+// setServer restarts snapclient onto a new server and saves it once that worked. A failed switch
+// goes back to the previous server, so the saved and active configs never disagree.
+func (sc *SnapController) setServer(config SnapServerConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	sc.mutex.Lock()
+	previousHost, previousPort := sc.host, sc.port
+	sc.host = config.Host
+	sc.port = strconv.Itoa(config.Port)
+	err = sc.writeServiceDefaultsLocked()
+	sc.mutex.Unlock()
+	if err == nil {
+		err = sc.control("restart")
+	}
+	if err != nil {
+		sc.mutex.Lock()
+		sc.host, sc.port = previousHost, previousPort
+		if restoreErr := sc.writeServiceDefaultsLocked(); restoreErr != nil {
+			log.Printf("Failed to restore the previous Snapcast server: %v", restoreErr)
+		}
+		sc.mutex.Unlock()
+		return err
+	}
+
+	if err := os.WriteFile(sc.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	return nil
+}
+
+// writeServiceDefaultsLocked points the systemd service at the current server, the caller must hold the mutex
+func (sc *SnapController) writeServiceDefaultsLocked() error {
+	if sc.mode != "systemctl" {
+		return nil
+	}
+
+	path := os.Getenv("SNAP_DEFAULTS_FILE")
+	if path == "" {
+		path = "/etc/default/snapclient"
+	}
+	opts := fmt.Sprintf("SNAPCLIENT_OPTS=\"--host %s --port %s\"\n", sc.host, sc.port)
+	if err := os.WriteFile(path, []byte(opts), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+func validateSnapServer(config SnapServerConfig) error {
+	if config.Host == "" || strings.ContainsAny(config.Host, " \t\"'") {
+		return fmt.Errorf("host must be a hostname or IP address")
+	}
+	if config.Port < 1 || config.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), snapDialTimeout)
+	if err != nil {
+		return fmt.Errorf("Snapcast server is not reachable: %v", err)
+	}
+	conn.Close()
+	return nil
+}
+
+func snapclientRunning() bool {
+	return exec.Command("pgrep", "-x", "snapclient").Run() == nil
+}
+
+// startLocked launches snapclient, the caller must hold the mutex
+func (sc *SnapController) startLocked() error {
+	if sc.mode == "systemctl" {
+		return exec.Command("systemctl", "start", sc.service).Run()
+	}
+
+	if snapclientRunning() {
+		return nil
+	}
+
+	args := []string{"-h", sc.host, "-p", sc.port, "--player", "pulse"}
+	if hostID := os.Getenv("HOST_ID"); hostID != "" {
+		args = append([]string{"--hostID", hostID}, args...)
+	}
+	cmd := exec.Command("snapclient", args...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	sc.cmd = cmd
+
+	// Reap the process whenever it exits so it doesn't linger as a zombie
+	go cmd.Wait()
+	return nil
+}
+
+// stopLocked stops snapclient, including instances started outside this server
+func (sc *SnapController) stopLocked() error {
+	if sc.mode == "systemctl" {
+		return exec.Command("systemctl", "stop", sc.service).Run()
+	}
+
+	sc.cmd = nil
+	if !snapclientRunning() {
+		return nil
+	}
+	return exec.Command("pkill", "-x", "snapclient").Run()
+}
+
+// waitForSnapclient polls pgrep until snapclient reaches the wanted state or the timeout expires
+func waitForSnapclient(running bool) bool {
+	deadline := time.Now().Add(snapVerifyTimeout)
+	for {
+		if snapclientRunning() == running {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// control runs start, stop or restart and verifies the outcome with pgrep
+func (sc *SnapController) control(action string) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if action == "stop" || action == "restart" {
+		if err := sc.stopLocked(); err != nil {
+			return fmt.Errorf("failed to stop snapclient: %v", err)
+		}
+		if !waitForSnapclient(false) {
+			return fmt.Errorf("snapclient is still running")
+		}
+	}
+
+	if action == "start" || action == "restart" {
+		if err := sc.startLocked(); err != nil {
+			return fmt.Errorf("failed to start snapclient: %v", err)
+		}
+		if !waitForSnapclient(true) {
+			return fmt.Errorf("snapclient did not start")
+		}
+	}
+	return nil
+}
+
+func handleSnapConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		config := snapController.config()
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := validateSnapServer(config); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := snapController.setServer(config); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		log.Printf("Snapcast server set to %s:%d via HTTP", config.Host, config.Port)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapController.config())
+}
+
+// handleSnapControl serves /api/snap/start, /api/snap/stop and /api/snap/restart
+func handleSnapControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	action := strings.TrimPrefix(r.URL.Path, "/api/snap/")
+	if action != "start" && action != "stop" && action != "restart" {
+		http.NotFound(w, r)
+		return
+	}
+
+	log.Printf("Snapclient %s requested via HTTP", action)
+	if err := snapController.control(action); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	status, _ := getSnapclientStatus()
+	if globalHub != nil {
+		broadcastSnapStatus(globalHub, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// watchSnapclient broadcasts a snap-status message whenever snapclient starts or stops
+func watchSnapclient(hub *Hub) {
+	ticker := time.NewTicker(snapStatusInterval)
+	defer ticker.Stop()
+
+	var lastRunning *bool
+	for {
+		status, _ := getSnapclientStatus()
+		running, _ := status["running"].(bool)
+
+		if lastRunning == nil || *lastRunning != running {
+			if lastRunning != nil {
+				log.Printf("Snapclient running changed to %t", running)
+				webhookNotifier.notify(webhookSnapclientChanged, map[string]interface{}{"running": running})
+			}
+			lastRunning = &running
+			broadcastSnapStatus(hub, status)
+		}
+		<-ticker.C
+	}
+}
+
+func broadcastSnapStatus(hub *Hub, status map[string]interface{}) {
+	msg := map[string]interface{}{"type": "snap-status"}
+	for key, value := range status {
+		msg[key] = value
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling snap status message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "snap status")
+}
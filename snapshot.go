@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// StateSnapshot is everything a display needs to render correctly right after connecting
+type StateSnapshot struct {
+	Type       string                 `json:"type"`
+	Brightness int                    `json:"brightness"`
+	Tab        string                 `json:"tab"`
+	Volume     int                    `json:"volume"`
+	Balance    int                    `json:"balance"`
+	Muted      bool                   `json:"muted"`
+	TimeFormat string                 `json:"timeFormat"`
+	Audio      ComponentStatus        `json:"audio"`
+	Listeners  AudioListenersMessage  `json:"audioListeners"`
+	Playing    bool                   `json:"audioPlaying"`
+	Banner     *Banner                `json:"banner,omitempty"`
+	Snapclient map[string]interface{} `json:"snapclient"`
+	ServerTime time.Time              `json:"serverTime"`
+	ClientID   string                 `json:"clientId"`
+}
+
+// stateSnapshot collects the current state as seen by one client
+func stateSnapshot(client *Client) StateSnapshot {
+	brightnessState.mutex.RLock()
+	brightness := brightnessState.value
+	brightnessState.mutex.RUnlock()
+
+	volumeState.mutex.RLock()
+	volume := volumeState.value
+	volumeState.mutex.RUnlock()
+
+	clockFormatState.mutex.RLock()
+	format := clockFormatState.value
+	clockFormatState.mutex.RUnlock()
+
+	client.mutex.RLock()
+	muted := client.muted
+	client.mutex.RUnlock()
+
+	snapStatus, _ := getSnapclientStatus()
+
+	return StateSnapshot{
+		Type:       "state-snapshot",
+		Brightness: brightness,
+		Tab:        currentTab(),
+		Volume:     volume,
+		Balance:    currentBalance(),
+		Muted:      muted,
+		TimeFormat: format,
+		Audio:      audioStatus(),
+		Listeners:  currentAudioListeners(),
+		Playing:    audioPlaying.get(),
+		Banner:     messageBanner.get(),
+		Snapclient: snapStatus,
+		ServerTime: time.Now(),
+		ClientID:   client.id,
+	}
+}
+
+// sendStateSnapshot replaces the get-brightness/get-tab round-trips a new client would otherwise
+// make, and answers get-state
+func sendStateSnapshot(client *Client) {
+	data, err := json.Marshal(stateSnapshot(client))
+	if err != nil {
+		log.Println("Error marshaling state snapshot:", err)
+		return
+	}
+	sendToClient(client, data)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// SetStateMessage changes several settings in one round trip, omitted fields are left unchanged
+type SetStateMessage struct {
+	Type       string  `json:"type"`
+	Brightness *int    `json:"brightness,omitempty"`
+	Tab        *string `json:"tab,omitempty"`
+	Volume     *int    `json:"volume,omitempty"`
+	Muted      *bool   `json:"muted,omitempty"`
+	TimeFormat *string `json:"timeFormat,omitempty"`
+}
+
+// StateResultMessage answers set-state with the resulting state, and when it was rejected, why
+// each invalid field was
+type StateResultMessage struct {
+	Type   string            `json:"type"`
+	Errors map[string]string `json:"errors,omitempty"` // Field name to error, nothing was applied
+	State  StateSnapshot     `json:"state"`
+}
+
+// StateUpdateMessage tells the other clients everything a set-state changed at once. Omitted fields
+// were left unchanged, Muted is the sending client's own mute state.
+type StateUpdateMessage struct {
+	Type       string  `json:"type"`
+	Brightness *int    `json:"brightness,omitempty"`
+	Tab        *string `json:"tab,omitempty"`
+	Volume     *int    `json:"volume,omitempty"`
+	Muted      *bool   `json:"muted,omitempty"`
+	TimeFormat *string `json:"timeFormat,omitempty"`
+	Source     string  `json:"source"` // ID of the client that sent the set-state
+}
+
+// validate checks every field, so the client learns about all of its mistakes at once
+func (msg *SetStateMessage) validate() map[string]string {
+	errors := map[string]string{}
+	if msg.Brightness != nil && (*msg.Brightness < 0 || *msg.Brightness > 100) {
+		errors["brightness"] = "Brightness must be between 0 and 100"
+	}
+	if msg.Tab != nil && !tabRegistry.valid(*msg.Tab) {
+		errors["tab"] = "Tab must be one of: " + strings.Join(tabRegistry.list(), ", ")
+	}
+	if msg.Volume != nil && (*msg.Volume < 0 || *msg.Volume > 100) {
+		errors["volume"] = "Volume must be between 0 and 100"
+	}
+	if msg.TimeFormat != nil && !isValidTimeFormat(*msg.TimeFormat) {
+		errors["timeFormat"] = "Time format must be one of: 12h, 24h"
+	}
+	return errors
+}
+
+// apply changes the state like the per-field handlers do, minus their broadcasts, and returns the
+// update for the other clients
+func (msg *SetStateMessage) apply(client *Client) StateUpdateMessage {
+	update := StateUpdateMessage{Type: "state-update", Source: client.id}
+	if msg.Brightness != nil {
+		brightness := *msg.Brightness
+		brightnessHistory.record(BrightnessChange{Brightness: brightness, Source: brightnessSourceWebSocket, ClientID: client.id})
+		brightnessSchedule.noteManualChange()
+		brightnessFader.stop()
+		setBrightness(brightness)
+		mqttBridge.publishBrightness(brightness)
+		update.Brightness = &brightness
+	}
+	if msg.Tab != nil {
+		tab := *msg.Tab
+		tabState.mutex.Lock()
+		tabState.value = tab
+		tabState.mutex.Unlock()
+		tabRotation.noteManualChange()
+		mqttBridge.publishTab(tab)
+		update.Tab = &tab
+	}
+	if msg.Volume != nil {
+		volume := setVolume(*msg.Volume)
+		update.Volume = &volume
+	}
+	if msg.Muted != nil {
+		muted := *msg.Muted
+		client.mutex.Lock()
+		client.muted = muted
+		client.mutex.Unlock()
+		update.Muted = &muted
+	}
+	if msg.TimeFormat != nil {
+		format := *msg.TimeFormat
+		clockFormatState.mutex.Lock()
+		clockFormatState.value = format
+		clockFormatState.mutex.Unlock()
+		update.TimeFormat = &format
+	}
+	return update
+}
+
+// broadcastStateUpdate sends a set-state's changes to every client but the sender, which gets the
+// result in its state-result instead
+func broadcastStateUpdate(hub *Hub, sender *Client, update StateUpdateMessage) {
+	data, err := json.Marshal(update)
+	if err != nil {
+		log.Println("Error marshaling state update message:", err)
+		return
+	}
+
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	for client := range hub.clients {
+		// Clients switch tabs without echoing, so record their new tab here as broadcastTab does
+		if update.Tab != nil {
+			client.mutex.Lock()
+			client.tab = *update.Tab
+			client.mutex.Unlock()
+		}
+		if client != sender {
+			sendToClient(client, data)
+		}
+	}
+}
+
+// handleSetStateMessage applies all fields or, if any is invalid, none of them. Other clients get
+// one state-update with every change, the sender gets a single state-result.
+func handleSetStateMessage(hub *Hub, client *Client, msg *SetStateMessage) {
+	errors := msg.validate()
+	if len(errors) == 0 {
+		log.Printf("Applying set-state from client %s", client.id)
+		broadcastStateUpdate(hub, client, msg.apply(client))
+	} else {
+		log.Printf("Rejected set-state from client %s: %v", client.id, errors)
+	}
+
+	data, err := json.Marshal(StateResultMessage{
+		Type:   "state-result",
+		Errors: errors,
+		State:  stateSnapshot(client),
+	})
+	if err != nil {
+		log.Println("Error marshaling state result message:", err)
+		return
+	}
+	sendToClient(client, data)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"compress/gzip"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// staticDir serves the frontend from disk instead of the copy embedded in the binary
+// (STATIC_DIR), for working on it without rebuilding. Relative to the working directory unless
+// absolute.
+var staticDir = os.Getenv("STATIC_DIR")
+
+// embeddedStatic is the frontend compiled into the binary, so a deploy is a single file
+//
+//go:embed static
+var embeddedStatic embed.FS
+
+// staticFiles picks where the UI is served from, exiting when STATIC_DIR is missing
+func staticFiles() fs.FS {
+	if staticDir == "" {
+		files, err := fs.Sub(embeddedStatic, "static")
+		if err != nil {
+			log.Fatalf("Embedded static files unavailable: %v", err)
+		}
+		log.Println("Serving embedded static files")
+		return files
+	}
+
+	info, err := os.Stat(staticDir)
+	if err != nil {
+		log.Fatalf("Static directory %q not found, check STATIC_DIR: %v", staticDir, err)
+	}
+	if !info.IsDir() {
+		log.Fatalf("Static directory %q is not a directory", staticDir)
+	}
+	log.Printf("Serving static files from %s", staticDir)
+	return os.DirFS(staticDir)
+}
+
+// gzipMinSize is the smallest response worth compressing, below it the gzip framing outweighs the savings
+const gzipMinSize = 1024
+
+// compressibleType reports whether a Content-Type is text-like. Images, fonts and audio are
+// already compressed and are served as is.
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(mediaType) {
+	case "text/html", "text/css", "text/plain", "text/javascript", "application/javascript",
+		"application/json", "application/manifest+json", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter decides when the headers are written whether the body gets compressed
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if !w.decided {
+		w.decided = true
+		header := w.Header()
+		if compressibleType(header.Get("Content-Type")) {
+			header.Add("Vary", "Accept-Encoding")
+
+			size, err := strconv.Atoi(header.Get("Content-Length"))
+			if status == http.StatusOK && header.Get("Content-Encoding") == "" && (err != nil || size >= gzipMinSize) {
+				header.Del("Content-Length")
+				header.Del("Accept-Ranges")
+				header.Set("Content-Encoding", "gzip")
+				w.gz = gzip.NewWriter(w.ResponseWriter)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// gzipStatic compresses text assets for clients that accept gzip. Range requests are passed
+// through untouched since byte offsets into a compressed body would be meaningless.
+func gzipStatic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Range") != "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(gw, r)
+		if gw.gz != nil {
+			gw.gz.Close()
+		}
+	})
+}
+
+// staticMaxAge is the Cache-Control max-age in seconds for unhashed assets (STATIC_MAX_AGE)
+var staticMaxAge = envInt("STATIC_MAX_AGE", 3600)
+
+// hashedAssetPattern matches build outputs with a content hash in the name, e.g. app.3f9c2a1b.js
+var hashedAssetPattern = regexp.MustCompile(`\.[0-9a-f]{8,}\.[a-z0-9]+$`)
+
+// cacheControl picks the caching policy for a static path
+func cacheControl(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".html"):
+		// Always revalidate so deploys show up immediately, the ETag keeps it cheap
+		return "no-cache"
+	case hashedAssetPattern.MatchString(name):
+		return "public, max-age=31536000, immutable"
+	default:
+		return fmt.Sprintf("public, max-age=%d", staticMaxAge)
+	}
+}
+
+// staticETag derives an ETag from the file's size and modification time. Embedded files have no
+// modification time, the process start stands in for it since a new build means a restart.
+func staticETag(info fs.FileInfo) string {
+	modTime := info.ModTime()
+	if modTime.IsZero() {
+		modTime = startTime
+	}
+	return fmt.Sprintf(`W/"%x-%x"`, info.Size(), modTime.UnixNano())
+}
+
+// cacheStatic sets Cache-Control and an ETag, which http.FileServer then uses to answer
+// If-None-Match with 304 without reading the file
+func cacheStatic(files fs.FS, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		if strings.HasSuffix(name, "/") {
+			name += "index.html"
+		}
+
+		info, err := fs.Stat(files, strings.TrimPrefix(name, "/"))
+		if err == nil && info.IsDir() {
+			name = path.Join(name, "index.html")
+			info, err = fs.Stat(files, strings.TrimPrefix(name, "/"))
+		}
+		if err == nil {
+			w.Header().Set("Cache-Control", cacheControl(name))
+			w.Header().Set("ETag", staticETag(info))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
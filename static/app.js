@@ -1,3 +1,6 @@
+// Newest WebSocket protocol version this frontend speaks, see /api/config
+const PROTOCOL_VERSION = 1;
+
 class SmartClock {
     constructor() {
         this.ws = null;
@@ -9,6 +12,12 @@ class SmartClock {
         this.currentTab = 0;
         this.tabs = ['clock', 'audio', 'settings', 'info'];
         this.timezone = 'UTC'; // Default timezone
+        // Restores this display's server-side state after a reconnect, kept across reloads
+        this.resumeToken = sessionStorage.getItem('resumeToken');
+        // Survives server restarts unlike the client ID, zones are keyed by it. ?display= names
+        // the display explicitly, otherwise a random one is kept in localStorage.
+        this.displayId = new URLSearchParams(window.location.search).get('display') || this.storedDisplayId();
+        this.clientId = null; // From the state-snapshot, to spot echoes of our own changes
         
         this.init();
     }
@@ -24,6 +33,9 @@ class SmartClock {
         
         // Check snapclient status every 10 seconds
         setInterval(() => this.checkSnapclientStatus(), 10000);
+
+        // Measure the round trip to the server every 5 seconds for the settings tab
+        setInterval(() => this.sendLatencyPing(), 5000);
         
         // Auto-start audio stream after a brief delay
         setTimeout(() => this.startAudioStream(), 1000);
@@ -70,7 +82,7 @@ class SmartClock {
         // No tab buttons to set up, just ensure swipe works
     }
 
-    switchToTab(index) {
+    switchToTab(index, notifyServer = true) {
         const tabContents = document.querySelectorAll('.tab-content');
         const tabName = this.tabs[index];
         
@@ -82,8 +94,8 @@ class SmartClock {
         // Add active class to the selected content
         document.getElementById(`${tabName}-tab`).classList.add('active');
         
-        // Send tab change to server
-        if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+        // Send tab change to server (not for changes the server asked for)
+        if (notifyServer && this.ws && this.ws.readyState === WebSocket.OPEN) {
             this.ws.send(JSON.stringify({
                 type: 'set-tab',
                 tab: tabName
@@ -245,16 +257,13 @@ class SmartClock {
         }
     }
 
-    sendCurrentBrightness() {
-        const slider = document.getElementById('brightnessSlider');
-        if (slider && this.ws && this.ws.readyState === WebSocket.OPEN) {
-            const brightness = parseInt(slider.value);
-            this.ws.send(JSON.stringify({
-                type: 'set-brightness',
-                brightness: brightness
-            }));
-            console.log('Sent current brightness to server:', brightness);
+    storedDisplayId() {
+        let id = localStorage.getItem('displayId');
+        if (!id) {
+            id = Math.random().toString(36).slice(2, 10) + Date.now().toString(36);
+            localStorage.setItem('displayId', id);
         }
+        return id;
     }
 
     connectWebSocket() {
@@ -265,7 +274,15 @@ class SmartClock {
         }
         
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        const wsUrl = `${protocol}//${window.location.host}/ws`;
+        // Forward the page's ?token= so the socket works when API_TOKEN is set
+        const params = new URLSearchParams();
+        const token = new URLSearchParams(window.location.search).get('token');
+        if (token) params.set('token', token);
+        if (this.resumeToken) params.set('resume', this.resumeToken);
+        params.set('display', this.displayId);
+        params.set('protocol', PROTOCOL_VERSION);
+        const query = params.toString() ? `?${params}` : '';
+        const wsUrl = `${protocol}//${window.location.host}/ws${query}`;
         
         this.ws = new WebSocket(wsUrl);
         
@@ -278,8 +295,7 @@ class SmartClock {
                 this.reconnectInterval = null;
             }
             
-            // Send current brightness to server on connect/reconnect
-            this.sendCurrentBrightness();
+            // Brightness and tab arrive in the server's state-snapshot, so nothing is pushed here
             
             // Restart audio stream when WebSocket reconnects
             if (!this.peerConnection || this.peerConnection.connectionState !== 'connected') {
@@ -294,14 +310,44 @@ class SmartClock {
                 // Handle WebRTC signaling messages
                 if (data.type === 'webrtc-answer') {
                     this.handleWebRTCAnswer(data.answer);
+                } else if (data.type === 'webrtc-offer') {
+                    this.handleWebRTCOffer(data.offer);
+                } else if (data.type === 'webrtc-rejected') {
+                    this.handleWebRTCRejected(data.message);
+                } else if (data.type === 'audio-error') {
+                    this.handleAudioError(data.message);
                 } else if (data.type === 'ice-candidate') {
                     this.handleICECandidate(data.candidate);
                 } else if (data.type === 'brightness-update') {
-                    this.handleBrightnessUpdate(data.brightness);
+                    // Our own changes are already applied, replaying their echoes makes the UI flicker
+                    if (data.source !== this.clientId) {
+                        this.handleBrightnessUpdate(data.brightness);
+                    }
                 } else if (data.type === 'tab-update') {
-                    this.handleTabUpdate(data.tab);
+                    if (data.source !== this.clientId) {
+                        this.handleTabUpdate(data.tab);
+                    }
+                } else if (data.type === 'state-update') {
+                    // Another display's set-state, our own arrives as a state-result
+                    if (data.brightness !== undefined) this.handleBrightnessUpdate(data.brightness);
+                    if (data.tab !== undefined) this.handleTabUpdate(data.tab);
                 } else if (data.type === 'refresh') {
                     this.handleRefresh();
+                } else if (data.type === 'pong') {
+                    this.handlePong(data);
+                } else if (data.type === 'session') {
+                    this.resumeToken = data.resumeToken;
+                    sessionStorage.setItem('resumeToken', data.resumeToken);
+                } else if (data.type === 'audio-listeners') {
+                    this.handleAudioListeners(data);
+                } else if (data.type === 'audio-playing') {
+                    this.handleAudioPlaying(data.playing);
+                } else if (data.type === 'state-snapshot') {
+                    this.clientId = data.clientId;
+                    this.handleBrightnessUpdate(data.brightness);
+                    this.handleTabUpdate(data.tab);
+                    this.handleAudioListeners(data.audioListeners);
+                    this.handleAudioPlaying(data.audioPlaying);
                 }
                 // Removed clock update handling - using local time now
             } catch (e) {
@@ -315,17 +361,34 @@ class SmartClock {
             this.updateStatusText('wsStatusText', 'Error', false);
         };
 
-        this.ws.onclose = () => {
-            console.log('WebSocket disconnected');
+        this.ws.onclose = (event) => {
+            if (event.code === 4001) {
+                // This build is too old for the server, reloading fetches the current one
+                console.error('WebSocket protocol unsupported:', event.reason);
+                this.updateStatus('wsStatus', 'Outdated', false);
+                this.updateStatusText('wsStatusText', 'Outdated', false);
+                setTimeout(() => window.location.reload(), 30000);
+                return;
+            }
+            if (event.code === 4003) {
+                // Reconnecting can't succeed without a valid ?token=
+                console.error('WebSocket unauthorized:', event.reason);
+                this.updateStatus('wsStatus', 'Unauthorized', false);
+                this.updateStatusText('wsStatusText', 'Unauthorized', false);
+                return;
+            }
+
+            console.log('WebSocket disconnected', event.code, event.reason);
             this.updateStatus('wsStatus', 'Disconnected', false);
             this.updateStatusText('wsStatusText', 'Disconnected', false);
             
-            // Try to reconnect every 5 seconds
+            // Try to reconnect every 5 seconds, backing off after a rate limit kick or server error
+            const delay = event.code === 1008 || event.code === 1011 ? 30000 : 5000;
             if (!this.reconnectInterval) {
                 this.reconnectInterval = setInterval(() => {
                     console.log('Attempting to reconnect...');
                     this.connectWebSocket();
-                }, 5000);
+                }, delay);
             }
         };
     }
@@ -509,6 +572,53 @@ class SmartClock {
         }
     }
 
+    sendLatencyPing() {
+        if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+            this.ws.send(JSON.stringify({ type: 'ping', timestamp: performance.now() }));
+        }
+    }
+
+    handlePong(data) {
+        const element = document.getElementById('latencyValue');
+        if (element) {
+            element.textContent = `${Math.round(performance.now() - data.timestamp)} ms`;
+        }
+    }
+
+    handleAudioListeners(counts) {
+        const element = document.getElementById('audioListeners');
+        if (element && counts) {
+            element.textContent = counts.listeners === 1 ? '1 device' : `${counts.listeners} devices`;
+        }
+    }
+
+    handleAudioPlaying(playing) {
+        const element = document.getElementById('audioPlaying');
+        if (element) {
+            element.textContent = playing ? 'Playing' : 'Paused';
+        }
+    }
+
+    handleWebRTCRejected(message) {
+        // The server is at its stream limit, keep retrying in case a slot frees up
+        console.warn('WebRTC offer rejected:', message);
+        if (this.peerConnection) {
+            this.peerConnection.close();
+            this.peerConnection = null;
+        }
+        this.isWebRTCConnecting = false;
+        this.updateStatus('audioStatus', 'Unavailable', false);
+        this.updateStatusText('audioStatusText', 'Unavailable', false);
+        this.scheduleWebRTCReconnect();
+    }
+
+    handleAudioError(message) {
+        // The server gave up encoding for this display, a new offer would likely fail the same way
+        console.error('Audio stream broken:', message);
+        this.updateStatus('audioStatus', 'Error', false);
+        this.updateStatusText('audioStatusText', 'Error', false);
+    }
+
     scheduleWebRTCReconnect() {
         if (this.webrtcReconnectInterval) {
             return; // Already scheduled
@@ -557,6 +667,26 @@ class SmartClock {
         }
     }
 
+    // Server-initiated renegotiation, e.g. after a track change
+    async handleWebRTCOffer(offer) {
+        try {
+            if (!this.peerConnection) {
+                console.error('No peer connection available for offer');
+                return;
+            }
+            await this.peerConnection.setRemoteDescription(new RTCSessionDescription(offer));
+            const answer = await this.peerConnection.createAnswer();
+            await this.peerConnection.setLocalDescription(answer);
+            this.ws.send(JSON.stringify({
+                type: 'webrtc-answer',
+                answer: this.peerConnection.localDescription
+            }));
+            console.log('Answered WebRTC renegotiation');
+        } catch (error) {
+            console.error('Error handling WebRTC offer:', error);
+        }
+    }
+
     async handleICECandidate(candidate) {
         try {
             if (this.peerConnection && candidate) {
@@ -623,7 +753,7 @@ class SmartClock {
         console.log('Received tab update:', tab);
         const tabIndex = this.tabs.indexOf(tab);
         if (tabIndex !== -1 && tabIndex !== this.currentTab) {
-            this.switchToTab(tabIndex);
+            this.switchToTab(tabIndex, false);
         }
     }
 
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Sun altitudes in degrees for the events we report
+const (
+	sunriseAltitude    = -0.833 // Upper limb on the horizon, corrected for refraction
+	goldenHourAltitude = 6.0
+)
+
+// SunLocation is the observer position from LATITUDE and LONGITUDE
+type SunLocation struct {
+	Latitude  float64
+	Longitude float64 // East positive
+}
+
+// SunTimes are the solar events for one day. Times are omitted when the sun never crosses that altitude,
+// in which case PolarDay or PolarNight says why.
+type SunTimes struct {
+	Type            string     `json:"type"`
+	Date            string     `json:"date"` // "2006-01-02"
+	Sunrise         *time.Time `json:"sunrise,omitempty"`
+	Sunset          *time.Time `json:"sunset,omitempty"`
+	SolarNoon       time.Time  `json:"solarNoon"`
+	GoldenHourEnd   *time.Time `json:"goldenHourEnd,omitempty"`   // Morning golden hour ends
+	GoldenHourStart *time.Time `json:"goldenHourStart,omitempty"` // Evening golden hour begins
+	PolarDay        bool       `json:"polarDay,omitempty"`        // Sun stays above the horizon all day
+	PolarNight      bool       `json:"polarNight,omitempty"`      // Sun stays below the horizon all day
+}
+
+var sunLocation = loadSunLocation()
+
+// loadSunLocation returns nil unless both LATITUDE and LONGITUDE are valid
+func loadSunLocation() *SunLocation {
+	latValue, lonValue := os.Getenv("LATITUDE"), os.Getenv("LONGITUDE")
+	if latValue == "" && lonValue == "" {
+		return nil
+	}
+
+	lat, latErr := strconv.ParseFloat(latValue, 64)
+	lon, lonErr := strconv.ParseFloat(lonValue, 64)
+	if latErr != nil || lonErr != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		log.Printf("Ignoring invalid LATITUDE/LONGITUDE %q/%q", latValue, lonValue)
+		return nil
+	}
+	return &SunLocation{Latitude: lat, Longitude: lon}
+}
+
+func julianToTime(jd float64, loc *time.Location) time.Time {
+	return time.Unix(0, int64((jd-2440587.5)*86400*float64(time.Second))).In(loc).Truncate(time.Second)
+}
+
+// compute uses the sunrise equation for the calendar day of date in its location
+func (l *SunLocation) compute(date time.Time) SunTimes {
+	const rad = math.Pi / 180
+
+	year, month, day := date.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	n := math.Ceil(float64(midnight.Unix())/86400 + 2440587.5 - 2451545.0 + 0.0008)
+
+	meanSolarTime := n - l.Longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*meanSolarTime, 360)
+	center := 1.9148*math.Sin(anomaly*rad) + 0.02*math.Sin(2*anomaly*rad) + 0.0003*math.Sin(3*anomaly*rad)
+	eclipticLongitude := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := 2451545.0 + meanSolarTime + 0.0053*math.Sin(anomaly*rad) - 0.0069*math.Sin(2*eclipticLongitude*rad)
+	declination := math.Asin(math.Sin(eclipticLongitude*rad) * math.Sin(23.4397*rad))
+
+	loc := date.Location()
+	times := SunTimes{
+		Type:      "sun-times",
+		Date:      date.Format("2006-01-02"),
+		SolarNoon: julianToTime(transit, loc),
+	}
+
+	// hourAngle is how far from solar noon, in days, the sun is at the given altitude
+	hourAngle := func(altitude float64) (float64, float64) {
+		lat := l.Latitude * rad
+		cosOmega := (math.Sin(altitude*rad) - math.Sin(lat)*math.Sin(declination)) / (math.Cos(lat) * math.Cos(declination))
+		if cosOmega < -1 || cosOmega > 1 {
+			return 0, cosOmega
+		}
+		return math.Acos(cosOmega) / (2 * math.Pi), cosOmega
+	}
+
+	if omega, cosOmega := hourAngle(sunriseAltitude); cosOmega < -1 {
+		times.PolarDay = true
+	} else if cosOmega > 1 {
+		times.PolarNight = true
+	} else {
+		sunrise, sunset := julianToTime(transit-omega, loc), julianToTime(transit+omega, loc)
+		times.Sunrise, times.Sunset = &sunrise, &sunset
+	}
+
+	if omega, cosOmega := hourAngle(goldenHourAltitude); cosOmega >= -1 && cosOmega <= 1 {
+		end, start := julianToTime(transit-omega, loc), julianToTime(transit+omega, loc)
+		times.GoldenHourEnd, times.GoldenHourStart = &end, &start
+	}
+	return times
+}
+
+func broadcastSunTimes(hub *Hub, times SunTimes) {
+	data, err := json.Marshal(times)
+	if err != nil {
+		log.Println("Error marshaling sun times message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "sun times")
+}
+
+// runSunTimes broadcasts the sun times on startup and again after every local midnight
+func runSunTimes(hub *Hub) {
+	if sunLocation == nil {
+		return
+	}
+
+	for {
+		loc := defaultLocation()
+		now := time.Now().In(loc)
+		times := sunLocation.compute(now)
+		log.Printf("Sun times for %s: polar day %v, polar night %v", times.Date, times.PolarDay, times.PolarNight)
+		broadcastSunTimes(hub, times)
+
+		year, month, day := now.Date()
+		time.Sleep(time.Until(time.Date(year, month, day+1, 0, 0, 1, 0, loc)))
+	}
+}
+
+// handleSunTimesMessage answers get-sun-times for a client that just connected
+func handleSunTimesMessage(client *Client) {
+	if sunLocation == nil {
+		sendError(client, "Latitude and longitude are not configured")
+		return
+	}
+
+	data, err := json.Marshal(sunLocation.compute(time.Now().In(defaultLocation())))
+	if err != nil {
+		log.Println("Error marshaling sun times message:", err)
+		return
+	}
+	sendToClient(client, data)
+}
+
+func handleSunTimes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if sunLocation == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Set LATITUDE and LONGITUDE to compute sun times")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sunLocation.compute(time.Now().In(defaultLocation())))
+}
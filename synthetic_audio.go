@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// testAudioSource replaces parec with a generated tone or a WAV file (AUDIO_TEST_SOURCE), for
+// machines without PulseAudio. "sine" plays 440Hz, "sine:<hz>" another pitch, anything else is
+// read as the path of a 48kHz 16-bit WAV file. See parseTestAudioSource.
+var testAudioSource = os.Getenv("AUDIO_TEST_SOURCE")
+
+// testTonePeriod is how long the tone plays and then pauses, longer than the default silence
+// window so the stream visibly pauses and resumes
+const testTonePeriod = time.Second
+
+// testToneAmplitude is about a quarter of full scale
+const testToneAmplitude = 8000
+
+// pacedReader produces s16le stereo PCM at the real-time rate, like a capture device would
+type pacedReader struct {
+	fill     func(frame []byte, offset int64) // Writes PCM starting at the given byte offset into the stream
+	start    time.Time
+	produced int64
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newPacedReader(fill func(frame []byte, offset int64)) *pacedReader {
+	return &pacedReader{fill: fill, start: time.Now(), closed: make(chan struct{})}
+}
+
+func (r *pacedReader) Read(p []byte) (int, error) {
+	n := len(p) - len(p)%captureFrameBytes
+	if n == 0 {
+		return 0, io.ErrShortBuffer
+	}
+
+	// Wait until the wall clock catches up with the data handed out so far
+	bytesPerSecond := int64(captureSampleRate * captureFrameBytes)
+	due := r.start.Add(time.Duration(r.produced * int64(time.Second) / bytesPerSecond))
+	select {
+	case <-r.closed:
+		return 0, io.EOF
+	case <-time.After(time.Until(due)):
+	}
+
+	// Never run more than 10ms ahead of real time
+	n = min(n, int(bytesPerSecond/100)/captureFrameBytes*captureFrameBytes)
+	r.fill(p[:n], r.produced)
+	r.produced += int64(n)
+	return n, nil
+}
+
+func (r *pacedReader) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return nil
+}
+
+// newToneReader generates a sine wave that alternates with silence every testTonePeriod
+func newToneReader(frequency float64) *pacedReader {
+	periodSamples := int64(captureSampleRate * testTonePeriod / time.Second)
+
+	return newPacedReader(func(frame []byte, offset int64) {
+		sampleIndex := offset / captureFrameBytes
+		for i := 0; i < len(frame); i += captureFrameBytes {
+			var sample int16
+			if (sampleIndex/periodSamples)%2 == 0 {
+				phase := 2 * math.Pi * frequency * float64(sampleIndex) / captureSampleRate
+				sample = int16(testToneAmplitude * math.Sin(phase))
+			}
+			binary.LittleEndian.PutUint16(frame[i:], uint16(sample))
+			binary.LittleEndian.PutUint16(frame[i+2:], uint16(sample))
+			sampleIndex++
+		}
+	})
+}
+
+// readWAV returns the sample data of a 48kHz 16-bit PCM WAV file as stereo s16le
+func readWAV(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%s is not a WAV file", path)
+	}
+
+	var channels, bitsPerSample uint16
+	var sampleRate uint32
+	var samples []byte
+	for chunk := data[12:]; len(chunk) >= 8; {
+		id := string(chunk[0:4])
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+		body := chunk[8:]
+		if size > len(body) {
+			size = len(body)
+		}
+
+		switch id {
+		case "fmt ":
+			if size < 16 || binary.LittleEndian.Uint16(body[0:2]) != 1 {
+				return nil, fmt.Errorf("%s is not uncompressed PCM", path)
+			}
+			channels = binary.LittleEndian.Uint16(body[2:4])
+			sampleRate = binary.LittleEndian.Uint32(body[4:8])
+			bitsPerSample = binary.LittleEndian.Uint16(body[14:16])
+		case "data":
+			samples = body[:size]
+		}
+
+		// Chunks are padded to an even size
+		chunk = body[min(size+size%2, len(body)):]
+	}
+
+	if sampleRate != captureSampleRate || bitsPerSample != 16 || (channels != 1 && channels != 2) {
+		return nil, fmt.Errorf("%s must be 48000Hz 16-bit mono or stereo, got %dHz %d-bit %d channel(s)", path, sampleRate, bitsPerSample, channels)
+	}
+	if channels == 1 {
+		stereo := make([]byte, 0, len(samples)*2)
+		for i := 0; i+1 < len(samples); i += 2 {
+			stereo = append(stereo, samples[i], samples[i+1], samples[i], samples[i+1])
+		}
+		samples = stereo
+	}
+
+	samples = samples[:len(samples)-len(samples)%captureFrameBytes]
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("%s has no audio data", path)
+	}
+	return samples, nil
+}
+
+// newWAVReader plays a WAV file in a loop
+func newWAVReader(path string) (*pacedReader, error) {
+	samples, err := readWAV(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPacedReader(func(frame []byte, offset int64) {
+		position := int(offset % int64(len(samples)))
+		for written := 0; written < len(frame); {
+			copied := copy(frame[written:], samples[position:])
+			written += copied
+			position = 0
+		}
+	}), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tabRotationGrace is how long a manual tab change holds off the rotation
+const tabRotationGrace = 2 * time.Minute
+
+// TabRotationConfig is the JSON shape of the rotation settings
+type TabRotationConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Tabs     []string `json:"tabs"`
+	Interval int      `json:"interval"` // Seconds each tab stays on screen
+}
+
+// TabRotation cycles the active tab through a list like a dashboard slideshow
+type TabRotation struct {
+	config TabRotationConfig
+	index  int       // Position of the tab shown last
+	nextAt time.Time // When to advance next
+	mutex  sync.Mutex
+}
+
+var tabRotation = &TabRotation{}
+
+func (tr *TabRotation) set(config TabRotationConfig) error {
+	if config.Enabled {
+		if len(config.Tabs) == 0 {
+			return fmt.Errorf("rotation needs at least one tab")
+		}
+		if config.Interval < 5 {
+			return fmt.Errorf("interval must be at least 5 seconds")
+		}
+	}
+	for _, tab := range config.Tabs {
+		if !tabRegistry.valid(tab) {
+			return fmt.Errorf("unknown tab %q", tab)
+		}
+	}
+
+	tr.mutex.Lock()
+	tr.config = config
+	tr.config.Tabs = append([]string(nil), config.Tabs...)
+	tr.index = -1
+	tr.nextAt = time.Now()
+	tr.mutex.Unlock()
+	return nil
+}
+
+func (tr *TabRotation) get() TabRotationConfig {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	config := tr.config
+	config.Tabs = append([]string{}, tr.config.Tabs...)
+	return config
+}
+
+// noteManualChange pauses the rotation after someone picked a tab
+func (tr *TabRotation) noteManualChange() {
+	tr.mutex.Lock()
+	if tr.config.Enabled {
+		tr.nextAt = time.Now().Add(tabRotationGrace)
+	}
+	tr.mutex.Unlock()
+}
+
+// due returns the next tab to show if the rotation should advance now
+func (tr *TabRotation) due(now time.Time) (string, bool) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	if !tr.config.Enabled || now.Before(tr.nextAt) {
+		return "", false
+	}
+
+	tr.index = (tr.index + 1) % len(tr.config.Tabs)
+	tr.nextAt = now.Add(time.Duration(tr.config.Interval) * time.Second)
+	return tr.config.Tabs[tr.index], true
+}
+
+// runTabRotation advances the active tab whenever the rotation is due
+func runTabRotation(hub *Hub) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		tab, ok := tabRotation.due(now)
+		if !ok || tab == currentTab() {
+			continue
+		}
+
+		tabState.mutex.Lock()
+		tabState.value = tab
+		tabState.mutex.Unlock()
+
+		broadcastTab(hub, tab, updateSourceRotation)
+	}
+}
+
+func handleTabRotation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req TabRotationConfig
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := tabRotation.set(req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Tab rotation set via HTTP: %+v", req)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tabRotation.get())
+}
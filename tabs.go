@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// defaultTabs are the tabs built into the frontend, always valid
+var defaultTabs = []string{"clock", "audio", "settings", "info"}
+
+var tabNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// TabRegistry holds the tab names the server accepts, custom ones are persisted to a JSON file
+type TabRegistry struct {
+	custom []string
+	path   string
+	mutex  sync.RWMutex
+}
+
+var tabRegistry = &TabRegistry{}
+
+func newTabRegistry(path string) *TabRegistry {
+	return &TabRegistry{path: path}
+}
+
+func (tr *TabRegistry) list() []string {
+	tr.mutex.RLock()
+	defer tr.mutex.RUnlock()
+	return append(append([]string{}, defaultTabs...), tr.custom...)
+}
+
+func (tr *TabRegistry) valid(tab string) bool {
+	for _, name := range tr.list() {
+		if name == tab {
+			return true
+		}
+	}
+	return false
+}
+
+// add registers new tab names, names that are already known are ignored
+func (tr *TabRegistry) add(tabs []string) error {
+	for _, tab := range tabs {
+		if !tabNamePattern.MatchString(tab) {
+			return fmt.Errorf("tab name %q must be lowercase letters, digits and dashes", tab)
+		}
+	}
+
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+
+	known := make(map[string]bool)
+	for _, name := range append(append([]string{}, defaultTabs...), tr.custom...) {
+		known[name] = true
+	}
+	for _, tab := range tabs {
+		if !known[tab] {
+			known[tab] = true
+			tr.custom = append(tr.custom, tab)
+		}
+	}
+
+	if err := tr.saveLocked(); err != nil {
+		log.Printf("Failed to persist tabs: %v", err)
+	}
+	return nil
+}
+
+func (tr *TabRegistry) load() error {
+	data, err := os.ReadFile(tr.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var tabs []string
+	if err := json.Unmarshal(data, &tabs); err != nil {
+		return err
+	}
+
+	tr.mutex.Lock()
+	tr.custom = tabs
+	tr.mutex.Unlock()
+	log.Printf("Loaded %d custom tabs from %s", len(tabs), tr.path)
+	return nil
+}
+
+// saveLocked writes the custom tabs to disk, the caller must hold the mutex
+func (tr *TabRegistry) saveLocked() error {
+	data, err := json.MarshalIndent(tr.custom, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tr.path, data, 0644)
+}
+
+func handleTabs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Tabs []string `json:"tabs"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := tabRegistry.add(req.Tabs); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("Registered tabs %v via HTTP", req.Tabs)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string][]string{"tabs": tabRegistry.list()}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
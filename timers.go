@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Timer is a running countdown
+type Timer struct {
+	ID        int
+	Label     string
+	ExpiresAt time.Time
+	cancel    chan struct{}
+}
+
+type TimerMessage struct {
+	Type      string `json:"type"`
+	ID        int    `json:"id,omitempty"`
+	Duration  int    `json:"duration,omitempty"`  // Seconds, for start-timer
+	Remaining int    `json:"remaining,omitempty"` // Seconds left, for timer-tick
+	Label     string `json:"label,omitempty"`
+}
+
+// TimerManager tracks active countdowns, each one removed from the map once done or cancelled
+type TimerManager struct {
+	timers map[int]*Timer
+	nextID int
+	mutex  sync.Mutex
+}
+
+var timerManager = &TimerManager{
+	timers: make(map[int]*Timer),
+	nextID: 1,
+}
+
+func (tm *TimerManager) start(hub *Hub, duration time.Duration, label string) *Timer {
+	tm.mutex.Lock()
+	timer := &Timer{
+		ID:        tm.nextID,
+		Label:     label,
+		ExpiresAt: time.Now().Add(duration),
+		cancel:    make(chan struct{}),
+	}
+	tm.nextID++
+	tm.timers[timer.ID] = timer
+	tm.mutex.Unlock()
+
+	log.Printf("Timer %d started for %s", timer.ID, duration)
+	go tm.run(hub, timer)
+	return timer
+}
+
+func (tm *TimerManager) cancel(id int) bool {
+	tm.mutex.Lock()
+	timer, ok := tm.timers[id]
+	if ok {
+		delete(tm.timers, id)
+		close(timer.cancel)
+	}
+	tm.mutex.Unlock()
+	return ok
+}
+
+// run broadcasts a tick every second until the timer expires or is cancelled
+func (tm *TimerManager) run(hub *Hub, timer *Timer) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		remaining := time.Until(timer.ExpiresAt)
+		if remaining <= 0 {
+			break
+		}
+
+		broadcastTimer(hub, TimerMessage{
+			Type:      "timer-tick",
+			ID:        timer.ID,
+			Remaining: int((remaining + time.Second - 1) / time.Second),
+			Label:     timer.Label,
+		})
+
+		select {
+		case <-timer.cancel:
+			tm.announceCancelled(hub, timer)
+			return
+		case <-ticker.C:
+		}
+	}
+
+	// A cancel that landed as the timer expired already removed it, so it ends as cancelled
+	tm.mutex.Lock()
+	_, pending := tm.timers[timer.ID]
+	delete(tm.timers, timer.ID)
+	tm.mutex.Unlock()
+	if !pending {
+		tm.announceCancelled(hub, timer)
+		return
+	}
+
+	log.Printf("Timer %d done", timer.ID)
+	broadcastTimer(hub, TimerMessage{Type: "timer-done", ID: timer.ID, Label: timer.Label})
+}
+
+func (tm *TimerManager) announceCancelled(hub *Hub, timer *Timer) {
+	log.Printf("Timer %d cancelled", timer.ID)
+	broadcastTimer(hub, TimerMessage{Type: "timer-cancelled", ID: timer.ID, Label: timer.Label})
+}
+
+func handleTimerMessage(hub *Hub, client *Client, msg *TimerMessage) {
+	switch msg.Type {
+	case "start-timer":
+		if msg.Duration <= 0 {
+			sendError(client, "Timer duration must be a positive number of seconds")
+			return
+		}
+		timerManager.start(hub, time.Duration(msg.Duration)*time.Second, msg.Label)
+	case "cancel-timer":
+		if !timerManager.cancel(msg.ID) {
+			sendError(client, "Timer not found")
+		}
+	}
+}
+
+func broadcastTimer(hub *Hub, msg TimerMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling timer message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "timer")
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimerCancelledAsItExpiresIsNotDone(t *testing.T) {
+	hub := newHub()
+	tm := &TimerManager{timers: make(map[int]*Timer), nextID: 1}
+	timer := &Timer{ID: 1, ExpiresAt: time.Now().Add(-time.Second), cancel: make(chan struct{})}
+	tm.timers[timer.ID] = timer
+
+	// The cancel wins the lock before run gets to announce the expiry
+	if !tm.cancel(timer.ID) {
+		t.Fatal("cancel did not find the timer")
+	}
+	tm.run(hub, timer)
+
+	var types []string
+	for len(hub.broadcast) > 0 {
+		var msg TimerMessage
+		if err := json.Unmarshal(<-hub.broadcast, &msg); err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, msg.Type)
+	}
+	if len(types) != 1 || types[0] != "timer-cancelled" {
+		t.Fatalf("broadcast %v, want only timer-cancelled", types)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// TimeStatus reports whether the host clock is kept in sync, as seen by systemd-timedated
+type TimeStatus struct {
+	ServerTime   time.Time `json:"serverTime"`
+	Synchronized *bool     `json:"synchronized,omitempty"` // Omitted when the sync state is unknown
+	NTPEnabled   *bool     `json:"ntpEnabled,omitempty"`
+	Offset       string    `json:"offset,omitempty"` // Last measured offset from the NTP server, e.g. "+1.234ms"
+	Error        string    `json:"error,omitempty"`
+}
+
+// parseKeyValues reads the KEY=value lines printed by timedatectl show
+func parseKeyValues(output []byte) map[string]string {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// timesyncOffset reads the last offset from systemd-timesyncd, empty if it isn't the NTP client in use
+func timesyncOffset() string {
+	output, err := exec.Command("timedatectl", "timesync-status").Output()
+	if err != nil {
+		return ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "Offset" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func readTimeStatus() TimeStatus {
+	status := TimeStatus{ServerTime: time.Now().In(defaultLocation())}
+
+	if _, err := exec.LookPath("timedatectl"); err != nil {
+		status.Error = "timedatectl is not available on this system"
+		return status
+	}
+
+	output, err := exec.Command("timedatectl", "show").Output()
+	if err != nil {
+		status.Error = fmt.Sprintf("failed to query time sync state: %v", err)
+		return status
+	}
+
+	values := parseKeyValues(output)
+	if value, ok := values["NTPSynchronized"]; ok {
+		synchronized := value == "yes"
+		status.Synchronized = &synchronized
+	}
+	if value, ok := values["NTP"]; ok {
+		enabled := value == "yes"
+		status.NTPEnabled = &enabled
+	}
+	status.Offset = timesyncOffset()
+	return status
+}
+
+func handleTimeStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readTimeStatus())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// TLSSettings come from TLS_CERT/TLS_KEY, or TLS_SELF_SIGNED=true for a generated LAN certificate
+type TLSSettings struct {
+	CertFile   string
+	KeyFile    string
+	SelfSigned bool
+	Port       string // TLS_PORT, default 8443
+	Redirect   bool   // HTTP_REDIRECT=true serves a redirect to HTTPS on the plain port
+}
+
+func tlsSettingsFromEnv() TLSSettings {
+	settings := TLSSettings{
+		CertFile:   os.Getenv("TLS_CERT"),
+		KeyFile:    os.Getenv("TLS_KEY"),
+		SelfSigned: os.Getenv("TLS_SELF_SIGNED") == "true",
+		Port:       os.Getenv("TLS_PORT"),
+		Redirect:   os.Getenv("HTTP_REDIRECT") == "true",
+	}
+	if settings.Port == "" {
+		settings.Port = "8443"
+	}
+	return settings
+}
+
+func (s TLSSettings) enabled() bool {
+	return s.SelfSigned || (s.CertFile != "" && s.KeyFile != "")
+}
+
+// generateSelfSignedCert creates a certificate for localhost, the hostname and local IPs
+func generateSelfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Smart Clock"}},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}, nil
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same host on the TLS port
+func redirectToHTTPS(tlsPort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			// No port, an IPv6 literal keeps its brackets which JoinHostPort adds back
+			host = strings.Trim(r.Host, "[]")
+		}
+		target := "https://" + net.JoinHostPort(host, tlsPort) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// Build info, injected with -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD)"
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+// startTime is when the process started, set at the top of main
+var startTime = time.Now()
+
+type VersionMessage struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+	Commit  string `json:"commit"` // Abbreviated to 7 characters
+}
+
+func uptime() time.Duration {
+	return time.Since(startTime).Truncate(time.Second)
+}
+
+func shortCommit() string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// sendVersion tells a newly connected client which build it is talking to
+func sendVersion(client *Client) {
+	data, err := json.Marshal(VersionMessage{
+		Type:    "version",
+		Version: version,
+		Commit:  shortCommit(),
+	})
+	if err != nil {
+		log.Println("Error marshaling version message:", err)
+		return
+	}
+	sendToClient(client, data)
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string]interface{}{
+		"version":       version,
+		"commit":        commit,
+		"goVersion":     runtime.Version(),
+		"startTime":     startTime,
+		"uptime":        uptime().String(),
+		"uptimeSeconds": int64(uptime() / time.Second),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
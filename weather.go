@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const openWeatherBaseURL = "https://api.openweathermap.org/data/2.5"
+
+// weatherForecastEntries is how many 3-hour forecast steps are kept (24 hours)
+const weatherForecastEntries = 8
+
+// WeatherConditions is the weather at one point in time
+type WeatherConditions struct {
+	Time        time.Time `json:"time"`
+	Temperature float64   `json:"temperature"`
+	FeelsLike   float64   `json:"feelsLike,omitempty"`
+	Humidity    int       `json:"humidity,omitempty"`
+	WindSpeed   float64   `json:"windSpeed,omitempty"`
+	Description string    `json:"description"`
+	Icon        string    `json:"icon"` // OpenWeatherMap icon code, e.g. "01d"
+}
+
+// WeatherReport is the cached weather, sent as the weather-update message
+type WeatherReport struct {
+	Type      string              `json:"type"`
+	Units     string              `json:"units"`
+	FetchedAt time.Time           `json:"fetchedAt"`
+	Stale     bool                `json:"stale"` // The last fetch failed, this is the previous good value
+	Current   WeatherConditions   `json:"current"`
+	Forecast  []WeatherConditions `json:"forecast"`
+}
+
+// openWeatherEntry is the subset of an OpenWeatherMap weather or forecast item we use
+type openWeatherEntry struct {
+	Dt   int64 `json:"dt"`
+	Main struct {
+		Temp      float64 `json:"temp"`
+		FeelsLike float64 `json:"feels_like"`
+		Humidity  int     `json:"humidity"`
+	} `json:"main"`
+	Wind struct {
+		Speed float64 `json:"speed"`
+	} `json:"wind"`
+	Weather []struct {
+		Description string `json:"description"`
+		Icon        string `json:"icon"`
+	} `json:"weather"`
+}
+
+func (e openWeatherEntry) conditions() WeatherConditions {
+	conditions := WeatherConditions{
+		Time:        time.Unix(e.Dt, 0),
+		Temperature: e.Main.Temp,
+		FeelsLike:   e.Main.FeelsLike,
+		Humidity:    e.Main.Humidity,
+		WindSpeed:   e.Wind.Speed,
+	}
+	if len(e.Weather) > 0 {
+		conditions.Description = e.Weather[0].Description
+		conditions.Icon = e.Weather[0].Icon
+	}
+	return conditions
+}
+
+// rateLimitError carries the provider's Retry-After so polling backs off
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited by weather provider, retrying in %s", e.retryAfter)
+}
+
+// WeatherService polls OpenWeatherMap and caches the last good report
+type WeatherService struct {
+	apiKey   string
+	units    string
+	interval time.Duration
+	client   *http.Client
+	report   *WeatherReport
+	mutex    sync.RWMutex
+}
+
+// newWeatherService returns nil unless OPENWEATHER_API_KEY and a location are configured
+func newWeatherService() *WeatherService {
+	apiKey := os.Getenv("OPENWEATHER_API_KEY")
+	if apiKey == "" {
+		return nil
+	}
+	if sunLocation == nil {
+		log.Println("OPENWEATHER_API_KEY is set but LATITUDE/LONGITUDE are not, weather disabled")
+		return nil
+	}
+
+	units := os.Getenv("WEATHER_UNITS")
+	if units == "" {
+		units = "metric"
+	}
+
+	return &WeatherService{
+		apiKey:   apiKey,
+		units:    units,
+		interval: time.Duration(envInt("WEATHER_INTERVAL", 10)) * time.Minute,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+var weatherService = newWeatherService()
+
+func (ws *WeatherService) fetchJSON(endpoint string, target interface{}) error {
+	query := url.Values{
+		"lat":   {strconv.FormatFloat(sunLocation.Latitude, 'f', 4, 64)},
+		"lon":   {strconv.FormatFloat(sunLocation.Longitude, 'f', 4, 64)},
+		"units": {ws.units},
+		"appid": {ws.apiKey},
+	}
+
+	resp, err := ws.client.Get(openWeatherBaseURL + "/" + endpoint + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := ws.interval
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return &rateLimitError{retryAfter: retryAfter}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("weather provider returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func (ws *WeatherService) fetch() (*WeatherReport, error) {
+	var current openWeatherEntry
+	if err := ws.fetchJSON("weather", &current); err != nil {
+		return nil, err
+	}
+
+	var forecast struct {
+		List []openWeatherEntry `json:"list"`
+	}
+	if err := ws.fetchJSON("forecast", &forecast); err != nil {
+		return nil, err
+	}
+
+	report := &WeatherReport{
+		Type:      "weather-update",
+		Units:     ws.units,
+		FetchedAt: time.Now(),
+		Current:   current.conditions(),
+		Forecast:  []WeatherConditions{},
+	}
+	for i, entry := range forecast.List {
+		if i >= weatherForecastEntries {
+			break
+		}
+		report.Forecast = append(report.Forecast, entry.conditions())
+	}
+	return report, nil
+}
+
+// get returns a copy of the cached report, nil before the first successful fetch
+func (ws *WeatherService) get() *WeatherReport {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	if ws.report == nil {
+		return nil
+	}
+	report := *ws.report
+	return &report
+}
+
+// refresh fetches new data, keeping the previous report marked stale when that fails
+func (ws *WeatherService) refresh() (*WeatherReport, time.Duration) {
+	report, err := ws.fetch()
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to fetch weather: %v", err)
+		if ws.report != nil {
+			ws.report.Stale = true
+		}
+
+		next := ws.interval
+		if rateLimited, ok := err.(*rateLimitError); ok {
+			next = rateLimited.retryAfter
+		}
+		if ws.report == nil {
+			return nil, next
+		}
+		report := *ws.report
+		return &report, next
+	}
+
+	ws.report = report
+	copied := *report
+	return &copied, ws.interval
+}
+
+// runWeather polls the provider and broadcasts every refresh, including stale ones
+func runWeather(hub *Hub) {
+	if weatherService == nil {
+		return
+	}
+
+	for {
+		report, next := weatherService.refresh()
+		if report != nil {
+			broadcastWeather(hub, report)
+		}
+		time.Sleep(next)
+	}
+}
+
+func broadcastWeather(hub *Hub, report *WeatherReport) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		log.Println("Error marshaling weather message:", err)
+		return
+	}
+
+	hub.tryBroadcast(data, "weather")
+}
+
+// handleWeatherMessage answers get-weather with the cached report
+func handleWeatherMessage(client *Client) {
+	if weatherService == nil {
+		sendError(client, "Weather is not configured")
+		return
+	}
+
+	report := weatherService.get()
+	if report == nil {
+		sendError(client, "Weather data is not available yet")
+		return
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		log.Println("Error marshaling weather message:", err)
+		return
+	}
+	sendToClient(client, data)
+}
+
+func handleWeather(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if weatherService == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Set OPENWEATHER_API_KEY, LATITUDE and LONGITUDE to enable weather")
+		return
+	}
+
+	report := weatherService.get()
+	if report == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Weather data is not available yet")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
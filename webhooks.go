@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Events a webhook is sent for
+const (
+	webhookClientConnected    = "client-connected"
+	webhookClientDisconnected = "client-disconnected"
+	webhookWebRTCConnected    = "webrtc-connected"
+	webhookWebRTCLost         = "webrtc-lost"
+	webhookAlarmFired         = "alarm-fired"
+	webhookSnapclientChanged  = "snapclient-changed"
+)
+
+var webhookEvents = []string{
+	webhookClientConnected, webhookClientDisconnected, webhookWebRTCConnected,
+	webhookWebRTCLost, webhookAlarmFired, webhookSnapclientChanged,
+}
+
+const (
+	webhookQueueSize  = 64 // Events waiting for delivery, newer ones are dropped once it is full
+	webhookAttempts   = 3
+	webhookRetryDelay = time.Second // Doubled after every failed attempt
+)
+
+// WebhookEvent is the JSON body POSTed to the webhook
+type WebhookEvent struct {
+	Event string                 `json:"event"`
+	Time  time.Time              `json:"time"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+// WebhookNotifier POSTs events to WEBHOOK_URL for home automation. Events are queued and sent in
+// order from a single goroutine, so a slow or unreachable receiver never blocks the caller.
+type WebhookNotifier struct {
+	url    string
+	events map[string]bool // Events to send, nil sends all of them
+	client *http.Client
+	queue  chan WebhookEvent
+}
+
+// newWebhookNotifier returns nil unless WEBHOOK_URL is set
+func newWebhookNotifier() *WebhookNotifier {
+	url := os.Getenv("WEBHOOK_URL")
+	if url == "" {
+		return nil
+	}
+
+	wn := &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: time.Duration(envInt("WEBHOOK_TIMEOUT", 5)) * time.Second},
+		queue:  make(chan WebhookEvent, webhookQueueSize),
+	}
+	if list := os.Getenv("WEBHOOK_EVENTS"); list != "" {
+		wn.events = make(map[string]bool)
+		for _, event := range strings.Split(list, ",") {
+			event = strings.TrimSpace(event)
+			known := false
+			for _, name := range webhookEvents {
+				known = known || name == event
+			}
+			if !known {
+				log.Printf("Ignoring unknown WEBHOOK_EVENTS entry %q, expected one of: %s", event, strings.Join(webhookEvents, ", "))
+				continue
+			}
+			wn.events[event] = true
+		}
+	}
+	return wn
+}
+
+var webhookNotifier = newWebhookNotifier()
+
+// notify queues an event without blocking, it is a no-op when webhooks are disabled
+func (wn *WebhookNotifier) notify(event string, data map[string]interface{}) {
+	if wn == nil || (wn.events != nil && !wn.events[event]) {
+		return
+	}
+
+	select {
+	case wn.queue <- WebhookEvent{Event: event, Time: time.Now(), Data: data}:
+	default:
+		log.Printf("Webhook queue full, dropping %s event", event)
+	}
+}
+
+// runWebhooks delivers queued events until the process exits
+func runWebhooks() {
+	if webhookNotifier == nil {
+		return
+	}
+
+	for event := range webhookNotifier.queue {
+		webhookNotifier.deliver(event)
+	}
+}
+
+// deliver POSTs one event, retrying network errors and 5xx responses with a backoff
+func (wn *WebhookNotifier) deliver(event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Error marshaling webhook event:", err)
+		return
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := wn.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			log.Printf("Webhook for %s event failed after %d attempt(s): %v", event.Event, attempt, err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends the body once, reporting whether a failure is worth retrying
+func (wn *WebhookNotifier) post(body []byte) (bool, error) {
+	resp, err := wn.client.Post(wn.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
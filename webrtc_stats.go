@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// PeerStats is a summary of a client's peer connection health
+type PeerStats struct {
+	State         string  `json:"state"`
+	BytesSent     uint64  `json:"bytesSent"`
+	PacketsSent   uint32  `json:"packetsSent"`
+	PacketsLost   int32   `json:"packetsLost"`
+	Jitter        float64 `json:"jitter"`            // Seconds, as reported by the receiver
+	RoundTripTime float64 `json:"roundTripTime"`     // Seconds
+	Bitrate       int     `json:"bitrate,omitempty"` // Opus bitrate adapted to the link, in bits per second
+}
+
+func collectPeerStats(pc *webrtc.PeerConnection) PeerStats {
+	stats := PeerStats{State: pc.ConnectionState().String()}
+	var transportBytes uint64
+
+	for _, s := range pc.GetStats() {
+		switch s := s.(type) {
+		case webrtc.OutboundRTPStreamStats:
+			stats.BytesSent += s.BytesSent
+			stats.PacketsSent += s.PacketsSent
+		case webrtc.RemoteInboundRTPStreamStats:
+			stats.PacketsLost += s.PacketsLost
+			stats.Jitter = s.Jitter
+			if s.RoundTripTime > 0 {
+				stats.RoundTripTime = s.RoundTripTime
+			}
+		case webrtc.ICECandidatePairStats:
+			// Fall back to the ICE round trip time when no RTCP report has arrived yet
+			if s.Nominated && stats.RoundTripTime == 0 {
+				stats.RoundTripTime = s.CurrentRoundTripTime
+			}
+		case webrtc.TransportStats:
+			transportBytes += s.BytesSent
+		}
+	}
+
+	if stats.BytesSent == 0 {
+		stats.BytesSent = transportBytes
+	}
+	return stats
+}
+
+func handleWebRTCStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string]PeerStats{}
+	if globalHub != nil {
+		globalHub.mutex.RLock()
+		for client := range globalHub.clients {
+			client.mutex.RLock()
+			peerConnection, audioTrack, bitrate := client.peerConnection, client.audioTrack, client.bitrate
+			client.mutex.RUnlock()
+			if peerConnection == nil {
+				continue
+			}
+
+			stats := collectPeerStats(peerConnection)
+			if audioTrack != nil && audioTrack.Codec().MimeType == webrtc.MimeTypeOpus {
+				stats.Bitrate = bitrate.target(audioConfig.get().Bitrate)
+			}
+			response[client.id] = stats
+		}
+		globalHub.mutex.RUnlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WorldClock is a named timezone shown alongside the main clock
+type WorldClock struct {
+	Label    string `json:"label"`
+	Timezone string `json:"timezone"`
+}
+
+// WorldClockTime is one entry of the worldclocks message
+type WorldClockTime struct {
+	Label    string `json:"label"`
+	Timezone string `json:"timezone"`
+	ClockData
+}
+
+type WorldClocksMessage struct {
+	Type   string           `json:"type"`
+	Clocks []WorldClockTime `json:"clocks"`
+}
+
+// WorldClockState holds the configured zones with their loaded locations
+type WorldClockState struct {
+	clocks    []WorldClock
+	locations []*time.Location
+	mutex     sync.RWMutex
+}
+
+var worldClockState = &WorldClockState{}
+
+func (ws *WorldClockState) set(clocks []WorldClock) error {
+	locations := make([]*time.Location, len(clocks))
+	for i, clock := range clocks {
+		if clock.Label == "" {
+			return fmt.Errorf("world clock for %q needs a label", clock.Timezone)
+		}
+		loc, err := time.LoadLocation(clock.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q", clock.Timezone)
+		}
+		locations[i] = loc
+	}
+
+	ws.mutex.Lock()
+	ws.clocks = append([]WorldClock(nil), clocks...)
+	ws.locations = locations
+	ws.mutex.Unlock()
+	return nil
+}
+
+func (ws *WorldClockState) get() []WorldClock {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	return append([]WorldClock{}, ws.clocks...)
+}
+
+// message builds the worldclocks payload, nil when no zones are configured
+func (ws *WorldClockState) message(now time.Time, format string, layout ClockLayout) []byte {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+
+	if len(ws.clocks) == 0 {
+		return nil
+	}
+
+	msg := WorldClocksMessage{
+		Type:   "worldclocks",
+		Clocks: make([]WorldClockTime, len(ws.clocks)),
+	}
+	for i, clock := range ws.clocks {
+		msg.Clocks[i] = WorldClockTime{
+			Label:     clock.Label,
+			Timezone:  clock.Timezone,
+			ClockData: newClockData(now.In(ws.locations[i]), format, layout),
+		}
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Error marshaling worldclocks message:", err)
+		return nil
+	}
+	return data
+}
+
+func handleWorldClocks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Clocks []WorldClock `json:"clocks"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if err := worldClockState.set(req.Clocks); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Printf("World clocks set with %d zones via HTTP", len(req.Clocks))
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	response := map[string][]WorldClock{"clocks": worldClockState.get()}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
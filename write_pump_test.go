@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledConn is a connection whose peer stopped reading, every write blocks until its deadline
+type stalledConn struct {
+	deadline  time.Time
+	closed    chan struct{}
+	closeOnce sync.Once
+	mutex     sync.Mutex
+}
+
+func newStalledConn() *stalledConn {
+	return &stalledConn{closed: make(chan struct{})}
+}
+
+func (c *stalledConn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *stalledConn) WriteMessage(int, []byte) error {
+	c.mutex.Lock()
+	deadline := c.deadline
+	c.mutex.Unlock()
+	return c.block(deadline)
+}
+
+func (c *stalledConn) WriteControl(_ int, _ []byte, deadline time.Time) error {
+	return c.block(deadline)
+}
+
+// block waits like a full socket buffer would, a zero deadline blocks until Close
+func (c *stalledConn) block(deadline time.Time) error {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		expired = time.After(time.Until(deadline))
+	}
+	select {
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
+
+func (c *stalledConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestWritePumpReturnsOnStalledConnection(t *testing.T) {
+	previous := wsWriteTimeout
+	wsWriteTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { wsWriteTimeout = previous })
+
+	tests := []struct {
+		name  string
+		queue func(client *Client)
+	}{
+		{"regular message", func(client *Client) { client.send <- []byte(`{"type":"time"}`) }},
+		{"priority message", func(client *Client) { client.priority <- []byte(`{"type":"answer"}`) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{id: "test", send: make(chan []byte, 1), priority: make(chan []byte, 1)}
+			conn := newStalledConn()
+			tt.queue(client)
+
+			done := make(chan struct{})
+			go func() {
+				writePump(client, conn)
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(wsWriteTimeout + time.Second):
+				conn.Close()
+				t.Fatalf("writePump still blocked %s after the write deadline", time.Second)
+			}
+			select {
+			case <-conn.closed:
+			default:
+				t.Fatal("writePump returned without closing the connection")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeUnauthorized closes connections without a valid API_TOKEN, from the 4000-4999 range
+// reserved for applications like closeProtocolUnsupported. The standard codes cover the rest:
+// policy violation for rate limit abuse, try again later for slow clients, going away on
+// shutdown and internal error when handling a message fails.
+const closeUnauthorized = 4003
+
+// closeWebSocket tells the client why it is being disconnected before closing the connection, so
+// the frontend can tell a kick from a network drop. WriteControl may be called concurrently
+// with the pumps.
+func closeWebSocket(conn wsWriter, code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	if err := conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(controlWriteWait)); err != nil {
+		log.Printf("Failed to send close message: %v", err)
+	}
+	conn.Close()
+}
+
+// rejectWebSocket closes a connection that was only upgraded to report why it is refused, because
+// browsers don't expose the status of a failed handshake
+func rejectWebSocket(conn *websocket.Conn, code int, reason string) {
+	log.Printf("Rejected WebSocket client: %s", reason)
+	closeWebSocket(conn, code, reason)
+}
+
+// closeAll disconnects every client with the same close code
+func (h *Hub) closeAll(code int, reason string) {
+	h.mutex.RLock()
+	var wg sync.WaitGroup
+	for client := range h.clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			closeWebSocket(client.conn, code, reason)
+		}(client)
+	}
+	h.mutex.RUnlock()
+	wg.Wait()
+}
+
+// handleShutdown says goodbye to the displays on SIGINT/SIGTERM so they reconnect to the
+// restarted server instead of treating it as a network failure
+func handleShutdown(hub *Hub) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	log.Printf("Received %s, closing WebSocket connections", sig)
+	hub.closeAll(websocket.CloseGoingAway, "Server shutting down")
+
+	mqttBridge.close()
+
+	// A recording in progress would otherwise be cut off with a WAV header claiming no end
+	if _, err := audioRecorder.stop(); err != nil && !errors.Is(err, errNotRecording) {
+		log.Printf("Failed to save recording: %v", err)
+	}
+	os.Exit(0)
+}
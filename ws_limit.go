@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// maxWebSocketConnections caps concurrent WebSocket connections (MAX_WS_CONNECTIONS). Each one
+// holds two goroutines and a 256 message send buffer, so a runaway script could otherwise exhaust
+// the memory of a Pi.
+var maxWebSocketConnections = envInt("MAX_WS_CONNECTIONS", 50)
+
+// activeWebSocketConnections counts connections holding a slot, from the upgrade until readPump exits
+var activeWebSocketConnections atomic.Int32
+
+// acquireWebSocketSlot works like acquirePeerSlot
+func acquireWebSocketSlot() func() {
+	return acquireSlot(&activeWebSocketConnections, maxWebSocketConnections)
+}
+
+// rejectWebSocketLimit refuses the upgrade, cheaper than accepting a connection only to close it
+func rejectWebSocketLimit(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Rejected WebSocket client %s: %d connections open", r.RemoteAddr, maxWebSocketConnections)
+	writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("Connection limit reached (%d concurrent WebSocket connections), try again later", maxWebSocketConnections))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+)
+
+var zoneNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+var (
+	errUnknownZone   = errors.New("unknown zone")
+	errZoneAndClient = errors.New("clientId and zone can't be combined")
+)
+
+// ZoneManager groups displays into named zones, e.g. the clocks of one room, which brightness, tab
+// and refresh commands can target together. Membership is persisted to a JSON file, so it is keyed
+// by display ID rather than by client IDs, which start over on every restart.
+type ZoneManager struct {
+	zones map[string][]string // Display IDs by zone, a display is in at most one zone
+	path  string
+	mutex sync.RWMutex
+}
+
+var zoneManager = newZoneManager("zones.json")
+
+func newZoneManager(path string) *ZoneManager {
+	return &ZoneManager{zones: make(map[string][]string), path: path}
+}
+
+func (zm *ZoneManager) list() map[string][]string {
+	zm.mutex.RLock()
+	defer zm.mutex.RUnlock()
+
+	zones := make(map[string][]string, len(zm.zones))
+	for name, displays := range zm.zones {
+		zones[name] = append([]string{}, displays...)
+	}
+	return zones
+}
+
+// zoneOf returns the zone a display belongs to, or "" when it isn't in one
+func (zm *ZoneManager) zoneOf(displayID string) string {
+	if displayID == "" {
+		return ""
+	}
+
+	zm.mutex.RLock()
+	defer zm.mutex.RUnlock()
+
+	for name, displays := range zm.zones {
+		for _, id := range displays {
+			if id == displayID {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// assign replaces the members of a zone, moving them out of the zones they were in. An empty list
+// deletes the zone.
+func (zm *ZoneManager) assign(name string, displays []string) error {
+	if !zoneNamePattern.MatchString(name) {
+		return fmt.Errorf("zone name %q must be lowercase letters, digits and dashes", name)
+	}
+
+	members := []string{}
+	seen := make(map[string]bool)
+	for _, id := range displays {
+		if !displayIDPattern.MatchString(id) {
+			return fmt.Errorf("display ID %q must be up to 64 letters, digits, dots, dashes and underscores", id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			members = append(members, id)
+		}
+	}
+
+	zm.mutex.Lock()
+	defer zm.mutex.Unlock()
+
+	delete(zm.zones, name)
+	for other, ids := range zm.zones {
+		kept := ids[:0]
+		for _, id := range ids {
+			if !seen[id] {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == 0 {
+			delete(zm.zones, other)
+		} else {
+			zm.zones[other] = kept
+		}
+	}
+	if len(members) > 0 {
+		zm.zones[name] = members
+	}
+
+	data, err := json.MarshalIndent(zm.zones, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(zm.path, data, 0644); err != nil {
+		log.Printf("Failed to persist zones: %v", err)
+	}
+	return nil
+}
+
+func (zm *ZoneManager) load() error {
+	data, err := os.ReadFile(zm.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	zones := make(map[string][]string)
+	if err := json.Unmarshal(data, &zones); err != nil {
+		return err
+	}
+	for name := range zones {
+		if !zoneNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid zone name %q", name)
+		}
+	}
+
+	zm.mutex.Lock()
+	zm.zones = zones
+	zm.mutex.Unlock()
+	log.Printf("Loaded %d zone(s) from %s", len(zones), zm.path)
+	return nil
+}
+
+// members returns the connected clients of a zone, members that are offline are skipped
+func (zm *ZoneManager) members(hub *Hub, name string) ([]*Client, error) {
+	zm.mutex.RLock()
+	ids, ok := zm.zones[name]
+	displays := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		displays[id] = true
+	}
+	zm.mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %s", errUnknownZone, name)
+	}
+	if hub == nil {
+		return nil, nil
+	}
+
+	// A display open in two tabs has two clients, both follow the zone
+	var clients []*Client
+	hub.mutex.RLock()
+	for client := range hub.clients {
+		if client.displayID != "" && displays[client.displayID] {
+			clients = append(clients, client)
+		}
+	}
+	hub.mutex.RUnlock()
+	return clients, nil
+}
+
+// zoneMembersForRequest looks up the connected members of a zone, writing a 404 if it is unknown
+func zoneMembersForRequest(w http.ResponseWriter, name string) ([]*Client, bool) {
+	clients, err := zoneManager.members(globalHub, name)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+	return clients, true
+}
+
+func handleZones(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Name     string   `json:"name"`
+			Displays []string `json:"displays"` // Empty deletes the zone
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := zoneManager.assign(req.Name, req.Displays); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("Zone %s set to displays %v", req.Name, req.Displays)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"zones": zoneManager.list()})
+}